@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"microservice/src/cache"
+	"microservice/src/controllers"
+	"microservice/src/models"
+	"microservice/src/repository"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func seedRecipes(b *testing.B, store repository.RecipeRepository, count int) {
+	for i := 0; i < count; i++ {
+		err := store.Create(context.Background(), models.Recipe{
+			ID:           primitive.NewObjectID(),
+			Name:         fmt.Sprintf("Recipe %d", i),
+			Tags:         []string{"main", "italian", "vegetarian"},
+			Ingredients:  []string{"500g flour", "2 eggs", "200ml milk", "1 pinch of salt", "50g butter"},
+			Instructions: []string{"Mix the flour and the eggs", "Add the milk slowly", "Rest for an hour", "Cook in a hot pan"},
+			PublishedAt:  time.Now().Add(-time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// legacyListRecipes is the handler as it was before pages were encoded by
+// the repository.
+func legacyListRecipes(store repository.RecipeRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := store.List(c.Request.Context(), repository.ListOptions{Limit: repository.MaxPageSize})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list := models.RecipeList{Recipes: page.Recipes}
+		if page.Next != nil {
+			list.NextCursor = page.Next.Encode()
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// discardResponse is a ResponseWriter dropping the body, as a connection
+// would once sent, where httptest.ResponseRecorder would keep growing a
+// buffer and count it against the handler.
+type discardResponse struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardResponse) Header() http.Header         { return w.header }
+func (w *discardResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponse) WriteHeader(code int)        { w.code = code }
+
+func benchmarkListRecipes(b *testing.B, handler gin.HandlerFunc) {
+	router := gin.New()
+	router.GET("/recipes", handler)
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/recipes?limit=%d", repository.MaxPageSize), nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := &discardResponse{header: make(http.Header)}
+		router.ServeHTTP(w, req)
+		if w.code != http.StatusOK {
+			b.Fatal(w.code)
+		}
+	}
+}
+
+// BenchmarkListRecipes compares GET /recipes?limit=100 served by the
+// encoded path against the previous implementation, which decoded the page
+// into a slice (or out of the cache) and let gin marshal it again. The
+// store runs stream the page as cache misses would, hashing it for the
+// ETag trailer, which the previous implementation did not send. Run with:
+//
+//	go test -run NONE -bench ListRecipes -benchmem
+func BenchmarkListRecipes(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	store := repository.NewMemoryRecipeRepository()
+	seedRecipes(b, store, 1000)
+	cached := repository.NewCachedRecipeRepository(store, cache.NewMemoryStore())
+
+	b.Run("store/legacy", func(b *testing.B) {
+		benchmarkListRecipes(b, legacyListRecipes(store))
+	})
+	b.Run("store/encoded", func(b *testing.B) {
+		benchmarkListRecipes(b, controllers.NewRecipesController(store, repository.NewMemoryRevisionRepository()).ListRecipes)
+	})
+	b.Run("cache/legacy", func(b *testing.B) {
+		benchmarkListRecipes(b, legacyListRecipes(cached))
+	})
+	b.Run("cache/encoded", func(b *testing.B) {
+		benchmarkListRecipes(b, controllers.NewRecipesController(cached, repository.NewMemoryRevisionRepository()).ListRecipes)
+	})
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/joho/godotenv"
@@ -14,8 +18,15 @@ import (
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 
 	_ "microservice/docs"
+	"microservice/src/cache"
 	"microservice/src/controllers"
+	"microservice/src/lockout"
+	"microservice/src/mail"
 	"microservice/src/middlewares"
+	"microservice/src/oidc"
+	"microservice/src/repository"
+	"microservice/src/session"
+	"microservice/src/signing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
@@ -26,16 +37,44 @@ import (
 
 var recipesController *controllers.RecipesController
 var authController *controllers.AuthController
+var healthController *controllers.HealthController
+var apiKeysController *controllers.APIKeysController
+var apiKeys repository.APIKeyRepository
+var sessionStore session.Store
+var signingKeys *signing.KeySet
 
 func init() {
 	godotenv.Load()
-	ctx := context.Background()
+	log.SetLevel(log.DebugLevel)
+}
+
+// setupControllers connects the controllers to their backing stores. Setting
+// RECIPES_STORE=memory runs the recipes API without MongoDB or Redis.
+func setupControllers(ctx context.Context) {
+	signingKeys = setupSigningKeys(ctx)
+
+	if os.Getenv("RECIPES_STORE") == "memory" {
+		log.Info("Using in-memory recipes store")
+		recipesRepository := repository.NewCachedRecipeRepository(repository.NewMemoryRecipeRepository(), cache.NewMemoryStore())
+		revisionsRepository := repository.NewMemoryRevisionRepository()
+		startTrashPurge(ctx, recipesRepository, revisionsRepository)
+		recipesController = controllers.NewRecipesController(recipesRepository, revisionsRepository)
+		sessionStore = session.NewMemoryStore()
+		apiKeys = repository.NewMemoryAPIKeyRepository()
+		apiKeysController = controllers.NewAPIKeysController(apiKeys)
+		authController = controllers.NewAuthController(signingKeys, repository.NewMemoryUserRepository(), setupMailer(), verifyURL()).
+			WithSessions(sessionStore, refreshTokenTTL()).
+			WithOIDC(setupOIDC(), cache.NewMemoryStore())
+		usernamePolicy, addressPolicy := lockoutPolicies()
+		authController.WithLockout(lockout.NewMemoryLimiter(usernamePolicy), lockout.NewMemoryLimiter(addressPolicy))
+		healthController = controllers.NewHealthController(nil, nil)
+		return
+	}
 
 	mongo_uri := os.Getenv("MONGO_URI")
 	mongo_db := os.Getenv("MONGO_DATABASE")
 
-	log.SetLevel(log.DebugLevel)
-	log.Debug("MONGO_URI: ", mongo_uri)
+	log.Debug("MONGO_URI: ", redactURI(mongo_uri))
 	log.Debug("MONGO_DATABASE: ", mongo_db)
 
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongo_uri))
@@ -48,23 +87,232 @@ func init() {
 	}
 	log.Info("Connected to MongoDB")
 
-	collection := client.Database(mongo_db).Collection("recipes")
+	recipesRepository := repository.NewMongoRecipeRepository(client.Database(mongo_db).Collection("recipes"))
+	if err = recipesRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
 
+	// Redis is optional: short timeouts let the circuit breaker notice an
+	// outage quickly and serve requests from MongoDB in the meantime.
 	redisClient := redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_URI"),
-		Password: "",
-		DB:       0,
+		Addr:         os.Getenv("REDIS_URI"),
+		Password:     "",
+		DB:           0,
+		DialTimeout:  time.Second,
+		ReadTimeout:  500 * time.Millisecond,
+		WriteTimeout: 500 * time.Millisecond,
 	})
+	cacheStore := cache.NewBreakerStore(cache.NewRedisStore(redisClient), func() error {
+		return redisClient.Ping().Err()
+	}, cache.BreakerOptions{Threshold: 3, ProbeInterval: 5 * time.Second})
+	log.Info("Redis available: ", cacheStore.Available())
+
+	// Each replica keeps hot recipes in memory; writes are broadcast over
+	// Redis pub/sub so the other replicas drop their copy.
+	localCacheSize, err := strconv.Atoi(getEnv("CACHE_LOCAL_SIZE", "1000"))
+	if err != nil {
+		log.Fatal("Invalid CACHE_LOCAL_SIZE: ", err)
+	}
+	localCacheTTL, err := time.ParseDuration(getEnv("CACHE_LOCAL_TTL", "30s"))
+	if err != nil {
+		log.Fatal("Invalid CACHE_LOCAL_TTL: ", err)
+	}
+	cachedRepository := repository.NewCachedRecipeRepository(recipesRepository, cacheStore).
+		WithLocalCache(localCacheSize, localCacheTTL, cache.NewRedisBus(redisClient, "recipes:invalidations"))
+
+	revisionsRepository := repository.NewMongoRevisionRepository(client.Database(mongo_db).Collection("recipe_revisions"))
+	if err = revisionsRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
 
-	status := redisClient.Ping()
-	log.Info("Connected to Redis: ", status)
+	startTrashPurge(ctx, cachedRepository, revisionsRepository)
+	recipesController = controllers.NewRecipesController(cachedRepository, revisionsRepository)
+	healthController = controllers.NewHealthController(func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}, cacheStore)
 
-	recipesController = controllers.NewRecipesController(ctx, collection, redisClient)
+	usersRepository := repository.NewMongoUserRepository(client.Database(mongo_db).Collection("users"))
+	if err = usersRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	apiKeysRepository := repository.NewMongoAPIKeyRepository(client.Database(mongo_db).Collection("api_keys"))
+	if err = apiKeysRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	apiKeys = apiKeysRepository
+	apiKeysController = controllers.NewAPIKeysController(apiKeys)
+
+	// Sessions live only in Redis, behind the cache's circuit breaker: while
+	// it is open, calls that need them fail fast. Sign in throttling falls
+	// back to counting on each replica.
+	sessionStore = session.NewGuardedStore(session.NewRedisStore(redisClient), cacheStore.Do)
+	authController = controllers.NewAuthController(signingKeys, usersRepository, setupMailer(), verifyURL()).
+		WithSessions(sessionStore, refreshTokenTTL()).
+		WithOIDC(setupOIDC(), cacheStore)
+	usernamePolicy, addressPolicy := lockoutPolicies()
+	authController.WithLockout(
+		lockout.NewFallbackLimiter(lockout.NewRedisLimiter(redisClient, "lockout:user:", usernamePolicy), lockout.NewMemoryLimiter(usernamePolicy), cacheStore.Do),
+		lockout.NewFallbackLimiter(lockout.NewRedisLimiter(redisClient, "lockout:ip:", addressPolicy), lockout.NewMemoryLimiter(addressPolicy), cacheStore.Do),
+	)
+}
+
+// setupMailer picks how sign up emails are delivered. MAIL_SENDER=smtp
+// relays through SMTP_ADDR; by default they are written to MAIL_DIR.
+func setupMailer() mail.Sender {
+	from := getEnv("MAIL_FROM", "recipes@localhost")
+	switch sender := getEnv("MAIL_SENDER", "file"); sender {
+	case "smtp":
+		return mail.NewSMTPSender(getEnv("SMTP_ADDR", "localhost:1025"), from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	case "file":
+		dir := getEnv("MAIL_DIR", "mail")
+		log.Info("Writing emails to ", dir)
+		return mail.NewFileSender(dir, from)
+	default:
+		log.Fatal("Invalid MAIL_SENDER: ", sender)
+		return nil
+	}
+}
+
+// setupSigningKeys loads the keys listed in JWT_KEYS_FILE, if any, and
+// reloads them every JWT_KEYS_RELOAD. Keys replaced by a newer one verify
+// tokens for JWT_KEY_GRACE more, which should outlast any token they
+// signed. JWT_SECRET signs tokens when no key is active and keeps verifying
+// the tokens it signed until JWT_KEY_GRACE after the first key is active.
+func setupSigningKeys(ctx context.Context) *signing.KeySet {
+	grace, err := time.ParseDuration(getEnv("JWT_KEY_GRACE", "24h"))
+	if err != nil || grace < 0 {
+		log.Fatal("Invalid JWT_KEY_GRACE: ", getEnv("JWT_KEY_GRACE", "24h"))
+	}
+	keys := signing.NewKeySet([]byte(os.Getenv("JWT_SECRET")), grace)
 
-	collectionUsers := client.Database(mongo_db).Collection("users")
-	authController = controllers.NewAuthController(ctx, collectionUsers)
+	manifest := os.Getenv("JWT_KEYS_FILE")
+	if manifest == "" {
+		log.Warn("JWT_KEYS_FILE is not set, signing tokens with JWT_SECRET")
+		return keys
+	}
+	loaded, err := signing.LoadManifest(manifest)
+	if err != nil {
+		log.Fatal("Failed to load signing keys: ", err)
+	}
+	keys.Replace(loaded)
+	log.Info("Loaded signing keys: ", len(loaded))
 
+	interval, err := time.ParseDuration(getEnv("JWT_KEYS_RELOAD", "1m"))
+	if err != nil || interval <= 0 {
+		log.Fatal("Invalid JWT_KEYS_RELOAD: ", getEnv("JWT_KEYS_RELOAD", "1m"))
+	}
+	go signing.ReloadManifest(ctx, keys, manifest, interval)
+	return keys
 }
+
+// setupOIDC configures sign in with the OpenID Connect provider at
+// OIDC_ISSUER, or returns nil when it is not set. The provider must allow
+// OIDC_REDIRECT_URL, by default /oidc/callback under PUBLIC_URL.
+func setupOIDC() *oidc.Provider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		log.Fatal("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	log.Info("Signing in with OpenID Connect provider ", issuer)
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", publicURL()+"/oidc/callback"),
+	}, &http.Client{Timeout: 10 * time.Second})
+}
+
+// lockoutPolicies throttles sign in after failed attempts. A username is
+// locked out for SIGNIN_LOCKOUT_DURATION after SIGNIN_LOCKOUT_ATTEMPTS
+// failures, a client address after five times as many, since many users
+// can share one behind a NAT. Earlier failures past the first few delay
+// the next attempt, doubling from a second.
+func lockoutPolicies() (lockout.Policy, lockout.Policy) {
+	attempts, err := strconv.ParseInt(getEnv("SIGNIN_LOCKOUT_ATTEMPTS", "10"), 10, 64)
+	if err != nil || attempts < 1 {
+		log.Fatal("Invalid SIGNIN_LOCKOUT_ATTEMPTS: ", getEnv("SIGNIN_LOCKOUT_ATTEMPTS", "10"))
+	}
+	duration, err := time.ParseDuration(getEnv("SIGNIN_LOCKOUT_DURATION", "15m"))
+	if err != nil || duration <= 0 {
+		log.Fatal("Invalid SIGNIN_LOCKOUT_DURATION: ", getEnv("SIGNIN_LOCKOUT_DURATION", "15m"))
+	}
+	usernames := lockout.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: attempts,
+		LockoutDuration: duration,
+		Window:          duration,
+	}
+	addresses := usernames
+	addresses.FreeAttempts *= 5
+	addresses.LockoutAttempts *= 5
+	return usernames, addresses
+}
+
+// refreshTokenTTL is how long a session lasts without being refreshed.
+func refreshTokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "168h"))
+	if err != nil || ttl <= 0 {
+		log.Fatal("Invalid REFRESH_TOKEN_TTL: ", getEnv("REFRESH_TOKEN_TTL", "168h"))
+	}
+	return ttl
+}
+
+// verifyURL is the address emailed verification links point at, under
+// PUBLIC_URL.
+func verifyURL() string {
+	return publicURL() + "/verify-email"
+}
+
+// publicURL is the address clients reach the API at.
+func publicURL() string {
+	return strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8000"), "/")
+}
+
+// trustedProxies lists the addresses, or CIDR ranges, whose
+// X-Forwarded-For header is believed, from TRUSTED_PROXIES. It defaults to
+// private networks, such as the one nginx reaches the API through, so
+// clients cannot dodge sign in throttling by making up addresses.
+func trustedProxies() []string {
+	proxies := getEnv("TRUSTED_PROXIES", "127.0.0.0/8,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,::1/128,fc00::/7")
+	return strings.Split(strings.ReplaceAll(proxies, " ", ""), ",")
+}
+
+// redactURI hides the password of a connection string before it is logged.
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "(invalid URI)"
+	}
+	return parsed.Redacted()
+}
+
+// startTrashPurge hard-deletes recipes and their history once they have
+// been in the trash for TRASH_RETENTION, checking every TRASH_PURGE_INTERVAL.
+func startTrashPurge(ctx context.Context, recipesRepository repository.RecipeRepository, revisionsRepository repository.RevisionRepository) {
+	retention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		log.Fatal("Invalid TRASH_RETENTION: ", err)
+	}
+	interval, err := time.ParseDuration(getEnv("TRASH_PURGE_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		log.Fatal("Invalid TRASH_PURGE_INTERVAL: ", getEnv("TRASH_PURGE_INTERVAL", "1h"))
+	}
+	go repository.PurgeDeleted(ctx, recipesRepository, revisionsRepository, retention, interval)
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
 func VersionHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"version": os.Getenv("API_VERSION")})
 }
@@ -88,24 +336,57 @@ func VersionHandler(c *gin.Context) {
 // @in header
 // @name Authorization
 func SetupServer() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.TrustedProxies = trustedProxies()
+	router.Use(middlewares.LoggerMiddleware(), gin.Recovery())
 	router.Use(cors.Default())
 	router.Use(middlewares.RequestIdMiddleware())
 	router.Use(middlewares.PrometheusMiddleware())
 
 	router.POST("/signin", authController.SignIn)
+	router.POST("/signup", authController.SignUp)
+	router.GET("/verify-email", authController.VerifyEmail)
+	router.POST("/verify-email/resend", authController.ResendVerification)
 	router.GET("/recipes", recipesController.ListRecipes)
+	router.GET("/recipes/search", recipesController.SearchRecipes)
 	router.POST("/refresh", authController.RefreshToken)
+	router.GET("/.well-known/jwks.json", authController.JWKS)
+	router.GET("/oidc/login", authController.OIDCLogin)
+	router.GET("/oidc/callback", authController.OIDCCallback)
+
+	// Handlers additionally check that only authors and moderators change
+	// an existing recipe.
+	read := middlewares.RequirePermission(middlewares.PermissionRecipesRead)
+	write := middlewares.RequirePermission(middlewares.PermissionRecipesWrite)
+	moderate := middlewares.RequirePermission(middlewares.PermissionRecipesModerate)
+	manage := middlewares.RequirePermission(middlewares.PermissionUsersManage)
 
 	authorized := router.Group("/")
-	authorized.Use(middlewares.AuthMiddleware())
+	authorized.Use(middlewares.AuthMiddleware(
+		middlewares.JWTAuthenticator(signingKeys.Keyfunc, sessionStore),
+		middlewares.APIKeyAuthenticator(apiKeys),
+	))
 	{
-		authorized.POST("/recipes", recipesController.NewRecipe)
-		authorized.PUT("/recipes/:id", recipesController.UpdateRecipe)
-		authorized.DELETE("/recipes/:id", recipesController.DeleteRecipe)
+		authorized.POST("/logout", authController.Logout)
+		authorized.POST("/tokens", authController.ScopedToken)
+		authorized.POST("/recipes", write, recipesController.NewRecipe)
+		authorized.PUT("/recipes/:id", write, recipesController.UpdateRecipe)
+		authorized.PATCH("/recipes/:id", write, recipesController.PatchRecipe)
+		authorized.DELETE("/recipes/:id", write, recipesController.DeleteRecipe)
+		authorized.GET("/trash", moderate, recipesController.ListTrash)
+		authorized.POST("/recipes/:id/restore", write, recipesController.RestoreRecipe)
+		authorized.GET("/recipes/:id/revisions", read, recipesController.ListRevisions)
+		authorized.GET("/recipes/:id/revisions/:rev", read, recipesController.GetRevision)
+		authorized.GET("/recipes/:id/revisions/:rev/diff", read, recipesController.DiffRevisions)
+		authorized.POST("/recipes/:id/revisions/:rev/revert", write, recipesController.RevertRecipe)
+		authorized.POST("/api-keys", manage, apiKeysController.NewAPIKey)
+		authorized.GET("/api-keys", manage, apiKeysController.ListAPIKeys)
+		authorized.DELETE("/api-keys/:id", manage, apiKeysController.RevokeAPIKey)
+		authorized.DELETE("/users/:username/lockout", manage, authController.Unlock)
 		router.GET("/recipes/:id", recipesController.GetRecipe)
 	}
 	router.GET("/version", VersionHandler)
+	router.GET("/health", healthController.Health)
 	router.GET("/prometheus", gin.WrapH(promhttp.Handler()))
 
 	// enable swagger doc
@@ -115,6 +396,7 @@ func SetupServer() *gin.Engine {
 }
 
 func main() {
+	setupControllers(context.Background())
 	if err := SetupServer().Run(":8000"); err != nil {
         log.Fatal("Failed to Run Server")
     }
@@ -1,42 +1,1594 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"microservice/src/cache"
+	"microservice/src/controllers"
+	"microservice/src/lockout"
+	"microservice/src/mail"
 	"microservice/src/models"
+	"microservice/src/oidc"
+	"microservice/src/oidc/oidctest"
+	"microservice/src/password"
+	"microservice/src/repository"
+	"microservice/src/session"
+	"microservice/src/signing"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SECRET", "test-secret")
+	dir, err := ioutil.TempDir("", "recipes-mail")
+	if err != nil {
+		panic(err)
+	}
+	testMailDir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testMailDir receives the emails sent by test servers.
+var testMailDir string
+
+// setupTestServer wires the controllers to a fresh in-memory store and cache
+// so each test starts from an empty collection.
+func setupTestServer() *gin.Engine {
+	testRecipes = repository.NewCachedRecipeRepository(repository.NewMemoryRecipeRepository(), cache.NewMemoryStore())
+	testRevisions = repository.NewMemoryRevisionRepository()
+	recipesController = controllers.NewRecipesController(testRecipes, testRevisions)
+	testUsers = repository.NewMemoryUserRepository(models.User{
+		Username: "packt",
+		// Stored the way users/main.go did before argon2id.
+		Password: string(sha256.New().Sum([]byte("RE4zfHB35VPtTkbT"))),
+		Role:     models.RoleEditor,
+	})
+	sessionStore = session.NewMemoryStore()
+	signingKeys = signing.NewKeySet([]byte(os.Getenv("JWT_SECRET")), time.Hour)
+	authController = controllers.NewAuthController(signingKeys, testUsers, mail.NewFileSender(testMailDir, "recipes@example.com"), "http://localhost:8000/verify-email").
+		WithSessions(sessionStore, time.Hour).
+		WithLockout(lockout.NewMemoryLimiter(testLockoutPolicy), lockout.NewMemoryLimiter(testAddressLockoutPolicy))
+	healthController = controllers.NewHealthController(nil, nil)
+	apiKeys = repository.NewMemoryAPIKeyRepository()
+	apiKeysController = controllers.NewAPIKeysController(apiKeys)
+	return SetupServer()
+}
+
+// testLockoutPolicy blocks a username for a minute from the third failed
+// sign in, so tests need not wait, and locks it out at the fifth.
+var testLockoutPolicy = lockout.Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Minute,
+	MaxDelay:        time.Minute,
+	LockoutAttempts: 5,
+	LockoutDuration: time.Hour,
+	Window:          time.Hour,
+}
+
+var testAddressLockoutPolicy = lockout.Policy{
+	FreeAttempts:    4,
+	BaseDelay:       time.Minute,
+	MaxDelay:        time.Minute,
+	LockoutAttempts: 10,
+	LockoutDuration: time.Hour,
+	Window:          time.Hour,
+}
+
+// testRecipes and testRevisions back the recipes API of the server built by
+// setupTestServer.
+var testRecipes repository.RecipeRepository
+var testRevisions repository.RevisionRepository
+
+// testUsers holds the accounts of the server built by setupTestServer.
+var testUsers *repository.MemoryUserRepository
+
+// testRoles maps the users signing test requests to their roles.
+var testRoles = map[string]string{
+	"admin":      models.RoleAdmin,
+	"packt":      models.RoleContributor,
+	"mlabouardy": models.RoleContributor,
+	"editor":     models.RoleEditor,
+	"viewer":     models.RoleViewer,
+}
+
+func signedToken(t *testing.T, username string) string {
+	claims := &models.Claims{
+		Username: username,
+		Role:     testRoles[username],
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	assert.Nil(t, err)
+	return token
+}
 
 func performRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, path, nil)
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 	return w
- }
- 
+}
+
+func performAuthorizedRequest(t *testing.T, r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return performIfMatchRequest(t, r, method, path, "", body)
+}
+
+func performIfMatchRequest(t *testing.T, r http.Handler, method, path, etag string, body interface{}) *httptest.ResponseRecorder {
+	return performRequestAs(t, r, "admin", method, path, etag, body)
+}
+
+func performRequestAs(t *testing.T, r http.Handler, username, method, path, etag string, body interface{}) *httptest.ResponseRecorder {
+	return performRequestWithToken(r, signedToken(t, username), method, path, etag, body)
+}
+
+func performRequestWithToken(r http.Handler, token, method, path, etag string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createRecipe(t *testing.T, r http.Handler, name string) models.Recipe {
+	return createRecipeWith(t, r, models.Recipe{
+		Name:        name,
+		Tags:        []string{"main"},
+		Ingredients: []string{"flour"},
+	})
+}
+
+func createRecipeWith(t *testing.T, r http.Handler, recipe models.Recipe) models.Recipe {
+	w := performAuthorizedRequest(t, r, http.MethodPost, "/recipes", recipe)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &created)
+	return created
+}
+
+func listRecipeNames(t *testing.T, r http.Handler, path string) []string {
+	w := performRequest(r, http.MethodGet, path)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list models.RecipeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	names := make([]string, 0, len(list.Recipes))
+	for _, recipe := range list.Recipes {
+		names = append(names, recipe.Name)
+	}
+	return names
+}
+
 func TestListRecipesHandler(t *testing.T) {
-	   // The setupServer method, that we previously refactored
-    // is injected into a test server
-	ts := httptest.NewServer(SetupServer())
+	// The setupServer method, that we previously refactored
+	// is injected into a test server
+	ts := httptest.NewServer(setupTestServer())
 	// Shut down the server and block until all requests have gone through
 	defer ts.Close()
 
 	// Make a request to our server with the {base url}/recipes
 	resp, err := http.Get(fmt.Sprintf("%s/recipes", ts.URL))
-	defer resp.Body.Close()
-	
 	assert.Nil(t, err)
+	defer resp.Body.Close()
+
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	data, _ := ioutil.ReadAll(resp.Body)
 
-	var recipes []models.Recipe
-	json.Unmarshal(data, &recipes)
-	assert.Equal(t, len(recipes), 0)
+	var list models.RecipeList
+	json.Unmarshal(data, &list)
+	assert.Equal(t, len(list.Recipes), 0)
+	assert.Equal(t, "", list.NextCursor)
+	assert.Equal(t, "", resp.Header.Get("Link"))
+}
+
+func TestListRecipesPagination(t *testing.T) {
+	r := setupTestServer()
+	for _, name := range []string{"Pizza", "Pasta", "Risotto", "Tiramisu", "Lasagna"} {
+		createRecipe(t, r, name)
+	}
+
+	seen := make(map[string]bool)
+	path := "/recipes?limit=2"
+	pages := 0
+	for path != "" {
+		w := performRequest(r, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var list models.RecipeList
+		json.Unmarshal(w.Body.Bytes(), &list)
+		assert.True(t, len(list.Recipes) <= 2)
+		for _, recipe := range list.Recipes {
+			assert.False(t, seen[recipe.Name], "recipe %s returned twice", recipe.Name)
+			seen[recipe.Name] = true
+		}
+
+		path = ""
+		if list.NextCursor != "" {
+			// Pages are streamed on their first request, the Link following
+			// the body.
+			assert.Contains(t, w.Result().Trailer.Get("Link"), "cursor="+list.NextCursor)
+			path = "/recipes?limit=2&cursor=" + list.NextCursor
+		}
+		pages++
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, 5, len(seen))
+}
+
+func TestListRecipesFilters(t *testing.T) {
+	r := setupTestServer()
+	createRecipeWith(t, r, models.Recipe{Name: "Pizza", Tags: []string{"italian", "main"}, Ingredients: []string{"Mozzarella cheese"}})
+	createRecipeWith(t, r, models.Recipe{Name: "Pasta", Tags: []string{"italian"}, Ingredients: []string{"Parmesan"}})
+	createRecipeWith(t, r, models.Recipe{Name: "Burger", Tags: []string{"main"}, Ingredients: []string{"Cheddar cheese"}})
+
+	assert.ElementsMatch(t, []string{"Pizza", "Pasta", "Burger"}, listRecipeNames(t, r, "/recipes?tags=italian,main"))
+	assert.Equal(t, []string{"Pizza"}, listRecipeNames(t, r, "/recipes?tags=italian,main&tags_match=all"))
+	assert.ElementsMatch(t, []string{"Pizza", "Burger"}, listRecipeNames(t, r, "/recipes?ingredient=CHEESE"))
+	assert.ElementsMatch(t, []string{"Pizza", "Pasta"}, listRecipeNames(t, r, "/recipes?name_prefix=P"))
+	assert.Equal(t, 0, len(listRecipeNames(t, r, "/recipes?published_before=2000-01-01")))
+	assert.Equal(t, 3, len(listRecipeNames(t, r, "/recipes?published_after=2000-01-01")))
+}
+
+func TestListRecipesSort(t *testing.T) {
+	r := setupTestServer()
+	for _, name := range []string{"Pizza", "Burger", "Pasta"} {
+		createRecipe(t, r, name)
+	}
+
+	assert.Equal(t, []string{"Burger", "Pasta", "Pizza"}, listRecipeNames(t, r, "/recipes?sort=name"))
+	assert.Equal(t, []string{"Pizza", "Pasta", "Burger"}, listRecipeNames(t, r, "/recipes?sort=-name"))
+	assert.Equal(t, []string{"Pizza", "Burger", "Pasta"}, listRecipeNames(t, r, "/recipes?sort=publishedAt"))
+
+	// Pages of a sorted list follow the same order.
+	w := performRequest(r, http.MethodGet, "/recipes?sort=name&limit=2")
+	var list models.RecipeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, []string{"Pizza"}, listRecipeNames(t, r, "/recipes?sort=name&limit=2&cursor="+list.NextCursor))
+
+	// A cursor is only valid for the order it was issued for.
+	w = performRequest(r, http.MethodGet, "/recipes?sort=-name&limit=2&cursor="+list.NextCursor)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListRecipesInvalidParameters(t *testing.T) {
+	r := setupTestServer()
+
+	w := performRequest(r, http.MethodGet, "/recipes?limit=0")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/recipes?cursor=garbage")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/recipes?sort=rating")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/recipes?tags_match=some")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/recipes?published_after=yesterday")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNewRecipeHandler(t *testing.T) {
+	r := setupTestServer()
+
+	recipe := createRecipe(t, r, "Pizza")
+	assert.False(t, recipe.ID.IsZero())
+	assert.False(t, recipe.PublishedAt.IsZero())
+
+	w := performRequest(r, http.MethodGet, "/recipes")
+	var list models.RecipeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, 1, len(list.Recipes))
+	assert.Equal(t, "Pizza", list.Recipes[0].Name)
+}
+
+func TestNewRecipeHandlerIgnoresDeletedAt(t *testing.T) {
+	r := setupTestServer()
+	deletedAt := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	recipe := createRecipeWith(t, r, models.Recipe{Name: "Pizza", DeletedAt: &deletedAt, DeletedBy: "packt"})
+	assert.Nil(t, recipe.DeletedAt)
+	assert.Equal(t, "", recipe.DeletedBy)
+
+	assert.Equal(t, []string{"Pizza"}, listRecipeNames(t, r, "/recipes"))
+	w := performAuthorizedRequest(t, r, http.MethodGet, "/trash", nil)
+	var trash models.RecipeList
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	assert.Equal(t, 0, len(trash.Recipes))
+}
+
+func TestNewRecipeHandlerRequiresToken(t *testing.T) {
+	r := setupTestServer()
+
+	w := performRequest(r, http.MethodPost, "/recipes")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetRecipeHandler(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+
+	w := performRequest(r, http.MethodGet, "/recipes/"+recipe.ID.Hex())
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var found models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, recipe.ID, found.ID)
+	assert.Equal(t, "Pizza", found.Name)
+
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+
+	w = performRequest(r, http.MethodGet, "/recipes/"+recipe.ID.Hex())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+
+	w = performRequest(r, http.MethodGet, "/recipes/000000000000000000000000")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/recipes/not-an-id")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUpdateRecipeHandler(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	assert.Equal(t, int64(1), recipe.Version)
+
+	w := performAuthorizedRequest(t, r, http.MethodPut, "/recipes/"+recipe.ID.Hex(), models.Recipe{
+		Name:    "Margherita",
+		Tags:    []string{"italian"},
+		Version: 1,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = performRequest(r, http.MethodGet, "/recipes/"+recipe.ID.Hex())
+	var found models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, "Margherita", found.Name)
+	assert.Equal(t, []string{"italian"}, found.Tags)
+	assert.Equal(t, int64(2), found.Version)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = performIfMatchRequest(t, r, http.MethodPut, "/recipes/"+recipe.ID.Hex(), `"2"`, models.Recipe{Name: "Marinara"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodPut, "/recipes/000000000000000000000000", models.Recipe{Name: "Ghost", Version: 1})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateRecipeConflict(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performAuthorizedRequest(t, r, http.MethodPut, path, models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = performIfMatchRequest(t, r, http.MethodPut, path, "W/\"1\"", models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performIfMatchRequest(t, r, http.MethodPut, path, `"1"`, models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A second editor still holding version 1 must not overwrite the change.
+	w = performIfMatchRequest(t, r, http.MethodPut, path, `"1"`, models.Recipe{Name: "Marinara"})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	var conflict struct {
+		Version int64 `json:"version"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	assert.Equal(t, int64(2), conflict.Version)
+
+	w = performRequest(r, http.MethodGet, path)
+	var found models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, "Margherita", found.Name)
+}
+
+func TestIfMatchAny(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	// * matches whatever version is current.
+	w := performIfMatchRequest(t, r, http.MethodPut, path, "*", models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "*", `{"name": "Marinara"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performIfMatchRequest(t, r, http.MethodPost, path+"/revisions/1/revert", "*", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performIfMatchRequest(t, r, http.MethodDelete, path, "*", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// But the recipe has to exist.
+	w = performIfMatchRequest(t, r, http.MethodPut, path, "*", models.Recipe{Name: "Calzone"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteRecipeHandler(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performAuthorizedRequest(t, r, http.MethodDelete, path, nil)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = performIfMatchRequest(t, r, http.MethodDelete, path, `"3"`, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = performIfMatchRequest(t, r, http.MethodDelete, path, `"1"`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, path)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performIfMatchRequest(t, r, http.MethodDelete, path, `"1"`, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTrashAndRestoreHandlers(t *testing.T) {
+	r := setupTestServer()
+	pizza := createRecipeWith(t, r, models.Recipe{Name: "Pizza", Tags: []string{"italian"}})
+	createRecipe(t, r, "Pancakes")
+	path := "/recipes/" + pizza.ID.Hex()
+
+	w := performIfMatchRequest(t, r, http.MethodDelete, path, `"1"`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []string{"Pancakes"}, listRecipeNames(t, r, "/recipes"))
+	w = performRequest(r, http.MethodGet, "/recipes/search?q=pizza")
+	var results models.RecipeSearchResults
+	json.Unmarshal(w.Body.Bytes(), &results)
+	assert.Equal(t, 0, len(results.Results))
+
+	w = performIfMatchRequest(t, r, http.MethodPut, path, `"2"`, models.Recipe{Name: "Calzone"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/trash")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, "/trash", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+	var trash models.RecipeList
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	assert.Equal(t, 1, len(trash.Recipes))
+	assert.Equal(t, "Pizza", trash.Recipes[0].Name)
+	assert.Equal(t, "admin", trash.Recipes[0].DeletedBy)
+	assert.NotNil(t, trash.Recipes[0].DeletedAt)
+
+	w = performAuthorizedRequest(t, r, http.MethodPost, path+"/restore", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var restored models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &restored)
+	assert.Equal(t, "Pizza", restored.Name)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, int64(3), restored.Version)
+
+	assert.Equal(t, []string{"Pancakes", "Pizza"}, listRecipeNames(t, r, "/recipes?sort=name"))
+	w = performAuthorizedRequest(t, r, http.MethodGet, "/trash", nil)
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	assert.Equal(t, 0, len(trash.Recipes))
+
+	w = performAuthorizedRequest(t, r, http.MethodPost, path+"/restore", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRecipeRevisionsHandlers(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipeWith(t, r, models.Recipe{Name: "Pizza", Tags: []string{"italian", "main"}})
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performIfMatchRequest(t, r, http.MethodPut, path, `"1"`, models.Recipe{
+		Name: "Margherita",
+		Tags: []string{"italian", "vegetarian"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list models.RevisionList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Equal(t, 2, len(list.Revisions))
+	assert.Equal(t, int64(2), list.Revisions[0].Number)
+	assert.Equal(t, "admin", list.Revisions[0].Author)
+	assert.Nil(t, list.Revisions[0].Recipe)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/1", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var revision models.Revision
+	json.Unmarshal(w.Body.Bytes(), &revision)
+	assert.Equal(t, "Pizza", revision.Recipe.Name)
+
+	// The first revision is compared with an empty recipe.
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/1/diff", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var diff models.RevisionDiff
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	assert.Equal(t, int64(0), diff.From)
+	assert.Equal(t, int64(1), diff.To)
+	assert.Equal(t, 2, len(diff.Changes))
+	assert.Equal(t, "Pizza", diff.Changes[0].To)
+	assert.Equal(t, []string{"italian", "main"}, diff.Changes[1].Added)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/2/diff", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	assert.Equal(t, int64(1), diff.From)
+	assert.Equal(t, int64(2), diff.To)
+	assert.Equal(t, 2, len(diff.Changes))
+	assert.Equal(t, "name", diff.Changes[0].Field)
+	assert.Equal(t, "Pizza", diff.Changes[0].From)
+	assert.Equal(t, "Margherita", diff.Changes[0].To)
+	assert.Equal(t, "tags", diff.Changes[1].Field)
+	assert.Equal(t, []string{"vegetarian"}, diff.Changes[1].Added)
+	assert.Equal(t, []string{"main"}, diff.Changes[1].Removed)
+
+	// Like any other write, a revert has to name the version it replaces.
+	w = performAuthorizedRequest(t, r, http.MethodPost, path+"/revisions/1/revert", nil)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	w = performAuthorizedRequest(t, r, http.MethodPost, path+"/revisions/1/revert", models.RevertRequest{Version: 1})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = performAuthorizedRequest(t, r, http.MethodPost, path+"/revisions/1/revert", models.RevertRequest{Version: 2})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var reverted models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &reverted)
+	assert.Equal(t, "Pizza", reverted.Name)
+	assert.Equal(t, []string{"italian", "main"}, reverted.Tags)
+	assert.Equal(t, int64(3), reverted.Version)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/3/diff?against=1", nil)
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	assert.Equal(t, 0, len(diff.Changes))
+
+	w = performIfMatchRequest(t, r, http.MethodPost, path+"/revisions/2/revert", `"1"`, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/9", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, path+"/revisions/first", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, "/recipes/000000000000000000000000/revisions", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Once trashed, only those who may browse the trash see the history.
+	w = performIfMatchRequest(t, r, http.MethodDelete, path, `"3"`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, revisionPath := range []string{"/revisions", "/revisions/1", "/revisions/2/diff"} {
+		w = performRequestAs(t, r, "viewer", http.MethodGet, path+revisionPath, "", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, revisionPath)
+		w = performAuthorizedRequest(t, r, http.MethodGet, path+revisionPath, nil)
+		assert.Equal(t, http.StatusOK, w.Code, revisionPath)
+	}
+
+	// Once purged, nobody does.
+	purged, cancel := context.WithCancel(context.Background())
+	cancel()
+	repository.PurgeDeleted(purged, testRecipes, testRevisions, -time.Hour, time.Minute)
+	for _, revisionPath := range []string{"/revisions", "/revisions/1", "/revisions/2/diff"} {
+		w = performAuthorizedRequest(t, r, http.MethodGet, path+revisionPath, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, revisionPath)
+	}
+	revisions, err := testRevisions.List(context.Background(), recipe.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(revisions))
+}
+
+func TestRecipeOwnership(t *testing.T) {
+	r := setupTestServer()
+	w := performRequestAs(t, r, "packt", http.MethodPost, "/recipes", "", models.Recipe{Name: "Pizza"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var recipe models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &recipe)
+	assert.Equal(t, "packt", recipe.Author)
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w = performRequestAs(t, r, "mlabouardy", http.MethodPut, path, `"1"`, models.Recipe{Name: "Calzone"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "mlabouardy", http.MethodDelete, path, `"1"`, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "mlabouardy", http.MethodPost, path+"/revisions/1/revert", `"1"`, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequestAs(t, r, "packt", http.MethodPut, path, `"1"`, models.Recipe{Name: "Margherita", Author: "mlabouardy"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestAs(t, r, "admin", http.MethodPut, path, `"2"`, models.Recipe{Name: "Marinara"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, path)
+	json.Unmarshal(w.Body.Bytes(), &recipe)
+	assert.Equal(t, "Marinara", recipe.Name)
+	assert.Equal(t, "packt", recipe.Author)
+
+	w = performRequestAs(t, r, "packt", http.MethodDelete, path, `"3"`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestAs(t, r, "mlabouardy", http.MethodPost, path+"/restore", "", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "packt", http.MethodPost, path+"/restore", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRecipeRoles(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performRequestAs(t, r, "viewer", http.MethodPost, "/recipes", "", models.Recipe{Name: "Calzone"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "viewer", http.MethodGet, path+"/revisions", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestAs(t, r, "packt", http.MethodGet, "/trash", "", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "editor", http.MethodGet, "/trash", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Editors may change recipes written by others.
+	w = performRequestAs(t, r, "editor", http.MethodPut, path, `"1"`, models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func signIn(r http.Handler, username, secret string) *httptest.ResponseRecorder {
+	return performJSONRequest(r, http.MethodPost, "/signin", models.User{Username: username, Password: secret})
+}
+
+func TestSignInRehashesLegacyPasswords(t *testing.T) {
+	r := setupTestServer()
+
+	w := signIn(r, "packt", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = signIn(r, "nobody", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = signIn(r, "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &output)
+	assert.NotEmpty(t, output.Token)
+
+	user, err := testUsers.FindByUsername(context.Background(), "packt")
+	assert.Nil(t, err)
+	ok, rehash, err := password.Verify("RE4zfHB35VPtTkbT", user.Password)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash, "legacy hash should have been replaced, got %q", user.Password)
+
+	w = signIn(r, "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// signInFrom signs in from the client address addr.
+func signInFrom(r http.Handler, addr, username, secret string) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(models.User{Username: username, Password: secret})
+	req, _ := http.NewRequest(http.MethodPost, "/signin", bytes.NewReader(encoded))
+	req.RemoteAddr = addr + ":41000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSignInLockout(t *testing.T) {
+	r := setupTestServer()
+
+	for i := 0; i < 3; i++ {
+		w := signInFrom(r, "203.0.113.1", "packt", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	// Blocked even with the right password, which is not checked.
+	w := signInFrom(r, "203.0.113.2", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	// Other users are unaffected.
+	hash, _ := password.Hash("s3cret")
+	testUsers.Create(context.Background(), models.User{Username: "baker", Password: hash, Role: models.RoleEditor})
+	w = signInFrom(r, "203.0.113.1", "baker", "s3cret")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestAs(t, r, "editor", http.MethodDelete, "/users/packt/lockout", "", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequestAs(t, r, "admin", http.MethodDelete, "/users/nobody/lockout", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = performRequestAs(t, r, "admin", http.MethodDelete, "/users/packt/lockout", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = signInFrom(r, "203.0.113.2", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, "/prometheus")
+	assert.Contains(t, w.Body.String(), `auth_events_total{event="signin",outcome="throttled"}`)
+	assert.Contains(t, w.Body.String(), `auth_events_total{event="unlock",outcome="admin"}`)
+}
+
+func TestSignInThrottlesAddresses(t *testing.T) {
+	r := setupTestServer()
+
+	// One guess each at many usernames.
+	for i := 0; i < 5; i++ {
+		w := signInFrom(r, "203.0.113.1", fmt.Sprintf("user%d", i), "password")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w := signInFrom(r, "203.0.113.1", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = signInFrom(r, "198.51.100.7", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Unlocking a user leaves the address blocked, unless it is named.
+	w = performRequestAs(t, r, "admin", http.MethodDelete, "/users/packt/lockout", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = signInFrom(r, "203.0.113.1", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = performRequestAs(t, r, "admin", http.MethodDelete, "/users/packt/lockout?ip=not-an-ip", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequestAs(t, r, "admin", http.MethodDelete, "/users/packt/lockout?ip=203.0.113.1", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = signInFrom(r, "203.0.113.1", "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func performJSONRequest(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var verificationLink = regexp.MustCompile(`http://localhost:8000(/verify-email\?token=[A-Za-z0-9_-]+)`)
+
+// lastVerificationLink returns the path of the newest verification link
+// mailed to email.
+func lastVerificationLink(t *testing.T, email string) string {
+	files, err := ioutil.ReadDir(testMailDir)
+	assert.Nil(t, err)
+	var latest string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "-"+email+".eml") && file.Name() > latest {
+			latest = file.Name()
+		}
+	}
+	if !assert.NotEmpty(t, latest, "no email sent to %s", email) {
+		return ""
+	}
+	content, err := ioutil.ReadFile(filepath.Join(testMailDir, latest))
+	assert.Nil(t, err)
+	match := verificationLink.FindStringSubmatch(string(content))
+	if !assert.NotNil(t, match) {
+		return ""
+	}
+	return match[1]
+}
+
+func TestSignUpAndVerifyEmail(t *testing.T) {
+	r := setupTestServer()
+	email := "cook-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@example.com"
+
+	w := performJSONRequest(r, http.MethodPost, "/signup", models.SignUpRequest{
+		Username: "cook",
+		Email:    " " + strings.ToUpper(email) + " ",
+		Password: "tomato basil",
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	firstLink := lastVerificationLink(t, email)
+
+	w = signIn(r, "cook", "tomato basil")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Asking again right away keeps the first link without sending another.
+	w = performJSONRequest(r, http.MethodPost, "/verify-email/resend", models.ResendVerificationRequest{Email: email})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, firstLink, lastVerificationLink(t, email))
+
+	// Once the link is older than the cooldown, asking again replaces it.
+	token := strings.SplitN(firstLink, "token=", 2)[1]
+	sum := sha256.Sum256([]byte(token))
+	testUsers.SetVerification(context.Background(), email, models.EmailVerification{
+		TokenHash: hex.EncodeToString(sum[:]),
+		ExpiresAt: time.Now().Add(23 * time.Hour),
+	}, time.Now().Add(48*time.Hour))
+	w = performJSONRequest(r, http.MethodPost, "/verify-email/resend", models.ResendVerificationRequest{Email: email})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	link := lastVerificationLink(t, email)
+	assert.NotEqual(t, firstLink, link)
+
+	w = performRequest(r, http.MethodGet, firstLink)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(r, http.MethodGet, link)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(r, http.MethodGet, link)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = signIn(r, "cook", "tomato basil")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &output)
+	w = performRequestWithToken(r, output.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Bruschetta"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performJSONRequest(r, http.MethodPost, "/verify-email/resend", models.ResendVerificationRequest{Email: email})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = performJSONRequest(r, http.MethodPost, "/verify-email/resend", models.ResendVerificationRequest{Email: "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestSignUpValidation(t *testing.T) {
+	r := setupTestServer()
+	valid := models.SignUpRequest{Username: "cook", Email: "cook@example.com", Password: "tomato basil"}
+	w := performJSONRequest(r, http.MethodPost, "/signup", valid)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	for name, test := range map[string]struct {
+		request models.SignUpRequest
+		status  int
+	}{
+		"taken username":   {models.SignUpRequest{Username: "cook", Email: "chef@example.com", Password: "tomato basil"}, http.StatusConflict},
+		"taken email":      {models.SignUpRequest{Username: "chef", Email: "COOK@example.com", Password: "tomato basil"}, http.StatusConflict},
+		"legacy username":  {models.SignUpRequest{Username: "packt", Email: "packt@example.com", Password: "tomato basil"}, http.StatusConflict},
+		"short username":   {models.SignUpRequest{Username: "ab", Email: "ab@example.com", Password: "tomato basil"}, http.StatusBadRequest},
+		"invalid username": {models.SignUpRequest{Username: "chef cook", Email: "chef@example.com", Password: "tomato basil"}, http.StatusBadRequest},
+		"invalid email":    {models.SignUpRequest{Username: "chef", Email: "chef@", Password: "tomato basil"}, http.StatusBadRequest},
+		"named email":      {models.SignUpRequest{Username: "chef", Email: "Chef <chef@example.com>", Password: "tomato basil"}, http.StatusBadRequest},
+		"short password":   {models.SignUpRequest{Username: "chef", Email: "chef@example.com", Password: "tomato"}, http.StatusBadRequest},
+		"password is name": {models.SignUpRequest{Username: "chefcook", Email: "chef@example.com", Password: "ChefCook"}, http.StatusBadRequest},
+		"missing email":    {models.SignUpRequest{Username: "chef", Password: "tomato basil"}, http.StatusBadRequest},
+	} {
+		w := performJSONRequest(r, http.MethodPost, "/signup", test.request)
+		assert.Equal(t, test.status, w.Code, name)
+	}
+}
+
+func signInTokens(t *testing.T, r http.Handler) models.JWTOutput {
+	w := signIn(r, "packt", "RE4zfHB35VPtTkbT")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &output)
+	assert.NotEmpty(t, output.RefreshToken)
+	return output
+}
+
+func refresh(r http.Handler, refreshToken string) *httptest.ResponseRecorder {
+	return performJSONRequest(r, http.MethodPost, "/refresh", models.RefreshRequest{RefreshToken: refreshToken})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	r := setupTestServer()
+	first := signInTokens(t, r)
+
+	w := refresh(r, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var second models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &second)
+	assert.NotEmpty(t, second.Token)
+	assert.NotEqual(t, first.Token, second.Token)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	w = performRequestWithToken(r, second.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = refresh(r, "not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = refresh(r, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Replaying the first refresh token revokes the whole session.
+	w = refresh(r, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = refresh(r, second.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = performRequestWithToken(r, second.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLogout(t *testing.T) {
+	r := setupTestServer()
+	tokens := signInTokens(t, r)
+	other := signInTokens(t, r)
+	scoped := performRequestWithToken(r, tokens.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	assert.Equal(t, http.StatusOK, scoped.Code)
+
+	w := performRequestWithToken(r, tokens.Token, http.MethodPost, "/logout", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithToken(r, tokens.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = refresh(r, tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Other sessions stay valid; tokens minted from this one do not.
+	w = refresh(r, other.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var minted models.JWTOutput
+	json.Unmarshal(scoped.Body.Bytes(), &minted)
+	revisions := "/recipes/" + createRecipe(t, r, "Pizza").ID.Hex() + "/revisions"
+	w = performRequestWithToken(r, minted.Token, http.MethodGet, revisions, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Signing out with a minted token revokes only that token.
+	scoped = performRequestWithToken(r, other.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	json.Unmarshal(scoped.Body.Bytes(), &minted)
+	w = performRequestWithToken(r, minted.Token, http.MethodPost, "/logout", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestWithToken(r, minted.Token, http.MethodGet, revisions, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = performRequestWithToken(r, other.Token, http.MethodGet, revisions, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSigningKeys(t *testing.T) {
+	r := setupTestServer()
+	legacy := signedToken(t, "admin")
+
+	w := performRequest(r, http.MethodGet, "/.well-known/jwks.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys": []}`, w.Body.String())
+
+	private, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(private)
+	key, err := signing.ParsePrivateKey("2026-10", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	signingKeys.Replace([]signing.Key{key})
+
+	w = performRequest(r, http.MethodGet, "/.well-known/jwks.json")
+	var jwks models.JWKSet
+	json.Unmarshal(w.Body.Bytes(), &jwks)
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "2026-10", jwks.Keys[0].KeyID)
+	assert.Equal(t, "ES256", jwks.Keys[0].Algorithm)
+
+	tokens := signInTokens(t, r)
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens.Token, &models.Claims{})
+	assert.Nil(t, err)
+	assert.Equal(t, "ES256", token.Header["alg"])
+	assert.Equal(t, "2026-10", token.Header["kid"])
+
+	w = performRequestWithToken(r, tokens.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	// Tokens signed with the secret before the switch stay valid.
+	w = performRequestWithToken(r, legacy, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// setupOIDCServer is setupTestServer signing users in with idp.
+func setupOIDCServer(idp *oidctest.IdP) *gin.Engine {
+	r := setupTestServer()
+	authController.WithOIDC(oidc.NewProvider(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://localhost:8000/oidc/callback",
+	}, idp.Server.Client()), cache.NewMemoryStore())
+	return r
+}
+
+// oidcSignIn starts signing in at /oidc/login, lets the provider redirect
+// back and returns the response of /oidc/callback.
+func oidcSignIn(t *testing.T, r http.Handler, idp *oidctest.IdP) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, oidcCallbackRequest(t, r, idp))
+	return w
+}
+
+// oidcCallbackRequest starts signing in and returns the request to
+// /oidc/callback the browser would make.
+func oidcCallbackRequest(t *testing.T, r http.Handler, idp *oidctest.IdP) *http.Request {
+	w := performRequest(r, http.MethodGet, "/oidc/login")
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	client := idp.Server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	response, err := client.Get(w.Header().Get("Location"))
+	assert.Nil(t, err)
+	response.Body.Close()
+	callback, err := url.Parse(response.Header.Get("Location"))
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	r := setupOIDCServer(idp)
+	idp.SignInAs(oidctest.User{Subject: "u-42", Email: "Cook@Example.com", EmailVerified: true, PreferredUsername: "home cook"})
+
+	w := oidcSignIn(t, r, idp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	w = performRequestWithToken(r, tokens.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Focaccia"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	user, err := testUsers.FindByUsername(context.Background(), "home-cook")
+	assert.Nil(t, err)
+	assert.Equal(t, models.DefaultRole, user.Role)
+	assert.Equal(t, "cook@example.com", user.Email)
+	assert.True(t, user.EmailVerified)
+
+	// The subject stays linked to the same user.
+	w = oidcSignIn(t, r, idp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	claims := &models.Claims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	assert.Nil(t, err)
+	assert.Equal(t, "home-cook", claims.Username)
+
+	// Another subject with the same name gets another username.
+	idp.SignInAs(oidctest.User{Subject: "u-43", PreferredUsername: "home cook"})
+	w = oidcSignIn(t, r, idp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	assert.True(t, strings.HasPrefix(claims.Username, "home-cook-"))
+}
+
+func TestOIDCStateIsUsedOnce(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	r := setupOIDCServer(idp)
+	idp.SignInAs(oidctest.User{Subject: "u-42", PreferredUsername: "home cook"})
+	req := oidcCallbackRequest(t, r, idp)
+
+	// Replayed callbacks racing the first one are refused.
+	codes := make(chan int, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req.Clone(context.Background()))
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		} else {
+			assert.Equal(t, http.StatusBadRequest, code)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	r := setupOIDCServer(idp)
+	ctx := context.Background()
+	testUsers.Create(ctx, models.User{Username: "baker", Role: models.RoleEditor, Email: "baker@example.com", EmailVerified: true})
+	testUsers.Create(ctx, models.User{Username: "squatter", Role: models.RoleEditor, Email: "chef@example.com"})
+
+	idp.SignInAs(oidctest.User{Subject: "u-1", Email: "baker@example.com", EmailVerified: true})
+	w := oidcSignIn(t, r, idp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	claims := &models.Claims{}
+	new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	assert.Equal(t, "baker", claims.Username)
+	assert.Equal(t, models.RoleEditor, claims.Role)
+
+	// An unverified address at either end is never linked.
+	for subject, user := range map[string]oidctest.User{
+		"u-2": {Subject: "u-2", Email: "baker@example.com", PreferredUsername: "impostor"},
+		"u-3": {Subject: "u-3", Email: "chef@example.com", EmailVerified: true, PreferredUsername: "chef"},
+	} {
+		idp.SignInAs(user)
+		w = oidcSignIn(t, r, idp)
+		assert.Equal(t, http.StatusOK, w.Code, subject)
+		json.Unmarshal(w.Body.Bytes(), &tokens)
+		new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+		assert.NotEqual(t, "baker", claims.Username, subject)
+		assert.NotEqual(t, "squatter", claims.Username, subject)
+		assert.Equal(t, models.DefaultRole, claims.Role, subject)
+	}
+	created, err := testUsers.FindByUsername(ctx, "chef")
+	assert.Nil(t, err)
+	assert.Empty(t, created.Email)
+}
+
+func TestOIDCRejectsForeignState(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	r := setupOIDCServer(idp)
+	idp.SignInAs(oidctest.User{Subject: "u-42"})
+
+	w := performRequest(r, http.MethodGet, "/oidc/login")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	// Without the cookie set by /oidc/login.
+	w = performRequest(r, http.MethodGet, "/oidc/callback?code=abc&state="+state)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// A state that was never issued.
+	req, _ := http.NewRequest(http.MethodGet, "/oidc/callback?code=abc&state=forged", nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "forged"})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r = setupTestServer()
+	w = performRequest(r, http.MethodGet, "/oidc/login")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func performAPIKeyRequest(r http.Handler, key, method, path string, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIKeys(t *testing.T) {
+	r := setupTestServer()
+
+	w := performRequestAs(t, r, "editor", http.MethodPost, "/api-keys", "", models.APIKeyRequest{Name: "cron"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{Name: "cron", Role: "chef"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{
+		Name:   "cron",
+		Role:   models.RoleViewer,
+		Scopes: []string{models.ScopeRecipesWrite},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{Name: "rss-producer"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var issued models.NewAPIKey
+	json.Unmarshal(w.Body.Bytes(), &issued)
+	assert.True(t, strings.HasPrefix(issued.Key, "rk_"+issued.Prefix+"_"))
+	assert.Equal(t, models.RoleContributor, issued.Role)
+	assert.Equal(t, []string{models.ScopeRecipesRead, models.ScopeRecipesWrite}, issued.Scopes)
+	assert.Equal(t, "admin", issued.CreatedBy)
+	assert.NotContains(t, w.Body.String(), "hash")
+
+	w = performAPIKeyRequest(r, issued.Key, http.MethodPost, "/recipes", models.Recipe{Name: "Tiramisu"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var recipe models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &recipe)
+	assert.Equal(t, "apikey:rss-producer", recipe.Author)
+
+	// Keys only grant their role.
+	w = performAPIKeyRequest(r, issued.Key, http.MethodGet, "/api-keys", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, "/api-keys", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list models.APIKeyList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Keys, 1)
+	assert.NotNil(t, list.Keys[0].LastUsedAt)
+	assert.Nil(t, list.Keys[0].RevokedAt)
+
+	tampered := issued.Key[:len(issued.Key)-1] + "x"
+	if tampered == issued.Key {
+		tampered = issued.Key[:len(issued.Key)-1] + "y"
+	}
+	for _, key := range []string{tampered, "rk_000000000000_secret", "not-a-key"} {
+		w = performAPIKeyRequest(r, key, http.MethodPost, "/recipes", models.Recipe{Name: "Tiramisu"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, key)
+	}
+
+	w = performAuthorizedRequest(t, r, http.MethodDelete, "/api-keys/"+issued.ID.Hex(), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performAPIKeyRequest(r, issued.Key, http.MethodPost, "/recipes", models.Recipe{Name: "Tiramisu"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodGet, "/api-keys", nil)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.NotNil(t, list.Keys[0].RevokedAt)
+
+	// The name is the identity recipes are written by: reusing it, even
+	// once revoked, would hand them to the new key.
+	w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{Name: "rss-producer"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	for _, name := range []string{"rss producer", "apikey:admin", "-cron", strings.Repeat("a", 65)} {
+		w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{Name: name})
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	w = performAuthorizedRequest(t, r, http.MethodDelete, "/api-keys/"+primitive.NewObjectID().Hex(), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestScopedTokens(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+
+	w := performRequestAs(t, r, "packt", http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes:    []string{models.ScopeRecipesRead},
+		ExpiresIn: 600,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var scoped models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &scoped)
+	assert.NotEmpty(t, scoped.Token)
+
+	w = performRequestWithToken(r, scoped.Token, http.MethodGet, "/recipes/"+recipe.ID.Hex()+"/revisions", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequestWithToken(r, scoped.Token, http.MethodPost, "/recipes", "", models.Recipe{Name: "Calzone"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="recipes:write"`, w.Header().Get("WWW-Authenticate"))
+	var denied struct {
+		Scope string `json:"scope"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &denied)
+	assert.Equal(t, models.ScopeRecipesWrite, denied.Scope)
+
+	// A scoped token cannot mint a broader one.
+	w = performRequestWithToken(r, scoped.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesWrite},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequestAs(t, r, "packt", http.MethodPost, "/tokens", "", models.ScopedTokenRequest{Scopes: []string{models.ScopeAdmin}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequestAs(t, r, "packt", http.MethodPost, "/tokens", "", models.ScopedTokenRequest{Scopes: []string{"recipes:everything"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequestAs(t, r, "packt", http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes:    []string{models.ScopeRecipesRead},
+		ExpiresIn: 7 * 24 * 3600,
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestScopedTokensStayWithinTheirParent(t *testing.T) {
+	r := setupTestServer()
+	tokens := signInTokens(t, r)
+
+	w := performRequestWithToken(r, tokens.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var scoped models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &scoped)
+	assert.False(t, scoped.Expires.After(tokens.Expires))
+	parent, minted := &models.Claims{}, &models.Claims{}
+	new(jwt.Parser).ParseUnverified(tokens.Token, parent)
+	new(jwt.Parser).ParseUnverified(scoped.Token, minted)
+	assert.Equal(t, parent.Session, minted.Session)
+	assert.True(t, minted.Minted)
+
+	// Minted tokens cannot renew themselves, even with fewer scopes.
+	w = performRequestWithToken(r, scoped.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Signing out revokes them with the session.
+	w = performRequestWithToken(r, tokens.Token, http.MethodPost, "/logout", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequestWithToken(r, scoped.Token, http.MethodPost, "/tokens", "", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performAuthorizedRequest(t, r, http.MethodPost, "/api-keys", models.APIKeyRequest{Name: "ci"})
+	var issued models.NewAPIKey
+	json.Unmarshal(w.Body.Bytes(), &issued)
+	w = performAPIKeyRequest(r, issued.Key, http.MethodPost, "/tokens", models.ScopedTokenRequest{
+		Scopes: []string{models.ScopeRecipesRead},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSearchRecipesHandler(t *testing.T) {
+	r := setupTestServer()
+	createRecipeWith(t, r, models.Recipe{
+		Name:         "Tomato soup",
+		Tags:         []string{"soup", "vegetarian"},
+		Ingredients:  []string{"4 ripe tomatoes", "1 onion"},
+		Instructions: []string{"Roast the tomatoes", "Blend until smooth"},
+	})
+	createRecipeWith(t, r, models.Recipe{
+		Name:        "Pizza",
+		Tags:        []string{"italian"},
+		Ingredients: []string{"Tomato sauce", "Mozzarella"},
+	})
+	createRecipeWith(t, r, models.Recipe{
+		Name:        "Pancakes",
+		Ingredients: []string{"Flour", "Milk"},
+	})
+
+	w := performRequest(r, http.MethodGet, "/recipes/search?q=tomato")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var results models.RecipeSearchResults
+	json.Unmarshal(w.Body.Bytes(), &results)
+	assert.Equal(t, 2, len(results.Results))
+	assert.Equal(t, "Tomato soup", results.Results[0].Recipe.Name)
+	assert.True(t, results.Results[0].Score > results.Results[1].Score)
+	assert.Equal(t, []string{"<em>Tomato</em> soup"}, results.Results[0].Highlights["name"])
+	assert.Equal(t, []string{"4 ripe <em>tomatoes</em>"}, results.Results[0].Highlights["ingredients"])
+
+	w = performRequest(r, http.MethodGet, "/recipes/search?q=the")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHealthHandler(t *testing.T) {
+	r := setupTestServer()
+
+	w := performRequest(r, http.MethodGet, "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var health models.Health
+	json.Unmarshal(w.Body.Bytes(), &health)
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, "disabled", health.Components["redis"])
+	assert.Equal(t, "up", health.Components["sessions"])
+
+	// With Redis down, the sessions kept there are reported down too.
+	redisDown := errors.New("connection refused")
+	healthController = controllers.NewHealthController(nil, cache.NewBreakerStore(cache.NewMemoryStore(), func() error {
+		return redisDown
+	}, cache.BreakerOptions{Threshold: 1, ProbeInterval: time.Hour}))
+	w = performRequest(SetupServer(), http.MethodGet, "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &health)
+	assert.Equal(t, "degraded", health.Status)
+	assert.Equal(t, "down", health.Components["redis"])
+	assert.Equal(t, "down", health.Components["sessions"])
+}
+
+func performConditionalRequest(r http.Handler, path, header, value string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(header, value)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetRecipeConditional(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performRequest(r, http.MethodGet, path)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+	assert.Equal(t, "public, max-age=10, must-revalidate", w.Header().Get("Cache-Control"))
+
+	w = performConditionalRequest(r, path, "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = performConditionalRequest(r, path, "If-None-Match", `"other", W/`+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = performConditionalRequest(r, path, "If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = performConditionalRequest(r, path, "If-Modified-Since", recipe.PublishedAt.Add(-time.Hour).UTC().Format(http.TimeFormat))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	time.Sleep(time.Second)
+	w = performIfMatchRequest(t, r, http.MethodPut, path, etag, models.Recipe{Name: "Margherita"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performConditionalRequest(r, path, "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	w = performConditionalRequest(r, path, "If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestListRecipesConditional(t *testing.T) {
+	r := setupTestServer()
+	createRecipe(t, r, "Pizza")
+
+	w := performRequest(r, http.MethodGet, "/recipes")
+	etag := w.Result().Trailer.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+
+	// Repeats are served from the cache, which can answer 304.
+	w = performConditionalRequest(r, "/recipes", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Result().Header.Get("ETag"))
+
+	createRecipe(t, r, "Pancakes")
+	w = performConditionalRequest(r, "/recipes", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Result().Trailer.Get("ETag"))
+}
+
+func TestListRecipesStreaming(t *testing.T) {
+	ts := httptest.NewServer(setupTestServer())
+	defer ts.Close()
+	for _, name := range []string{"Pizza", "Pasta", "Risotto"} {
+		createRecipe(t, ts.Config.Handler, name)
+	}
+
+	get := func() (*http.Response, models.RecipeList) {
+		resp, err := http.Get(ts.URL + "/recipes?limit=2")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		var list models.RecipeList
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&list))
+		ioutil.ReadAll(resp.Body)
+		return resp, list
+	}
+
+	// A miss is streamed from the store: what depends on the whole page
+	// comes after it.
+	resp, list := get()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Empty(t, resp.Header.Get("ETag"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	etag := resp.Trailer.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Contains(t, resp.Trailer.Get("Link"), "cursor="+list.NextCursor)
+	assert.Equal(t, 2, len(list.Recipes))
+
+	// A hit is sent whole, with the same ETag.
+	resp, cached := get()
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Contains(t, resp.Header.Get("Link"), "cursor="+list.NextCursor)
+	assert.Equal(t, list, cached)
+}
+
+func performPatchRequest(t *testing.T, r http.Handler, path, contentType, etag, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", signedToken(t, "admin"))
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchRecipeHandler(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipeWith(t, r, models.Recipe{
+		Name:         "Pizza",
+		Tags:         []string{"italian"},
+		Ingredients:  []string{"flour", "water", "salt"},
+		Instructions: []string{"Knead", "Bake"},
+	})
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"tags":["italian","vegetarian"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	var patched models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &patched)
+	assert.Equal(t, []string{"italian", "vegetarian"}, patched.Tags)
+	assert.Equal(t, "Pizza", patched.Name)
+	assert.Equal(t, []string{"flour", "water", "salt"}, patched.Ingredients)
+	assert.Equal(t, int64(2), patched.Version)
+
+	w = performPatchRequest(t, r, path, "application/json-patch+json", `"2"`, `[
+		{"op": "test", "path": "/ingredients/1", "value": "water"},
+		{"op": "remove", "path": "/ingredients/1"},
+		{"op": "add", "path": "/ingredients/-", "value": "yeast"},
+		{"op": "move", "from": "/instructions/1", "path": "/instructions/0"}
+	]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(r, http.MethodGet, path)
+	var found models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, []string{"flour", "salt", "yeast"}, found.Ingredients)
+	assert.Equal(t, []string{"Bake", "Knead"}, found.Instructions)
+	assert.Equal(t, []string{"italian", "vegetarian"}, found.Tags)
+	assert.Equal(t, int64(3), found.Version)
+}
+
+func TestPatchRecipeRejectsInvalidPatches(t *testing.T) {
+	r := setupTestServer()
+	recipe := createRecipe(t, r, "Pizza")
+	path := "/recipes/" + recipe.ID.Hex()
+
+	w := performPatchRequest(t, r, path, "application/json", "", `{"name":"Calzone"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/json-patch+json", "", `[{"op":"remove","path":"/tags/7"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/json-patch+json", "", `[{"op":"test","path":"/name","value":"Calzone"}]`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"version":7}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"name":null}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"tags":"main"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", "", `{"rating":5}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = performPatchRequest(t, r, path, "application/merge-patch+json", `"4"`, `{"name":"Calzone"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = performPatchRequest(t, r, "/recipes/000000000000000000000000", "application/merge-patch+json", "", `{"name":"Calzone"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performRequest(r, http.MethodGet, path)
+	var found models.Recipe
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, "Pizza", found.Name)
+	assert.Equal(t, int64(1), found.Version)
+}
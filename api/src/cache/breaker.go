@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned by BreakerStore while the circuit is open.
+var ErrUnavailable = errors.New("cache: store unavailable")
+
+var storeAvailable = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "cache_store_available",
+		Help: "1 when the cache store is reachable, 0 while the circuit breaker is open",
+	},
+)
+
+var breakerTrips = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "cache_breaker_trips_total",
+		Help: "Number of times the cache circuit breaker opened",
+	},
+)
+
+func init() {
+	prometheus.Register(storeAvailable)
+	prometheus.Register(breakerTrips)
+}
+
+type BreakerOptions struct {
+	// Threshold is the number of consecutive failures that opens the circuit.
+	Threshold int
+	// ProbeInterval is the delay between recovery probes while open.
+	ProbeInterval time.Duration
+}
+
+// BreakerStore is a circuit breaker around another Store. After Threshold
+// consecutive failures every call fails fast with ErrUnavailable, and a
+// background goroutine probes the store until it recovers. Invalidations
+// requested while open are queued and replayed before the circuit closes, so
+// entries written before the outage cannot be served stale afterwards.
+type BreakerStore struct {
+	store   Store
+	probe   func() error
+	options BreakerOptions
+
+	mu          sync.Mutex
+	failures    int
+	open        bool
+	pendingKeys map[string]bool
+	pendingTags map[string]bool
+}
+
+// NewBreakerStore wraps store. probe checks whether the store is reachable
+// again, for Redis a PING. The circuit starts open when probe fails.
+func NewBreakerStore(store Store, probe func() error, options BreakerOptions) *BreakerStore {
+	breaker := &BreakerStore{
+		store:       store,
+		probe:       probe,
+		options:     options,
+		pendingKeys: make(map[string]bool),
+		pendingTags: make(map[string]bool),
+	}
+	storeAvailable.Set(1)
+	if err := probe(); err != nil {
+		log.Warn("Cache store unavailable at startup: ", err)
+		breaker.mu.Lock()
+		breaker.trip()
+		breaker.mu.Unlock()
+	}
+	return breaker
+}
+
+// Available reports whether the circuit is closed.
+func (breaker *BreakerStore) Available() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return !breaker.open
+}
+
+func (breaker *BreakerStore) Get(key string) ([]byte, error) {
+	if !breaker.Available() {
+		return nil, ErrUnavailable
+	}
+	value, err := breaker.store.Get(key)
+	breaker.record(err)
+	return value, err
+}
+
+func (breaker *BreakerStore) Take(key string) ([]byte, error) {
+	if !breaker.Available() {
+		return nil, ErrUnavailable
+	}
+	value, err := breaker.store.Take(key)
+	breaker.record(err)
+	return value, err
+}
+
+func (breaker *BreakerStore) Set(key string, value []byte, ttl time.Duration) error {
+	return breaker.call(func() error { return breaker.store.Set(key, value, ttl) })
+}
+
+func (breaker *BreakerStore) Tag(tag string, keys ...string) error {
+	return breaker.call(func() error { return breaker.store.Tag(tag, keys...) })
+}
+
+// Delete queues the keys and returns nil when the store cannot be reached.
+func (breaker *BreakerStore) Delete(keys ...string) error {
+	err := breaker.call(func() error { return breaker.store.Delete(keys...) })
+	if err != nil {
+		breaker.mu.Lock()
+		for _, key := range keys {
+			breaker.pendingKeys[key] = true
+		}
+		breaker.mu.Unlock()
+		log.Warn("Queued cache invalidation until the store recovers: ", err)
+	}
+	return nil
+}
+
+// DeleteTags queues the tags and returns nil when the store cannot be reached.
+func (breaker *BreakerStore) DeleteTags(tags ...string) error {
+	err := breaker.call(func() error { return breaker.store.DeleteTags(tags...) })
+	if err != nil {
+		breaker.mu.Lock()
+		for _, tag := range tags {
+			breaker.pendingTags[tag] = true
+		}
+		breaker.mu.Unlock()
+		log.Warn("Queued cache invalidation until the store recovers: ", err)
+	}
+	return nil
+}
+
+// Do runs fn through the circuit, so other clients of the same server share
+// its view of an outage: fn is skipped with ErrUnavailable while open, and
+// its error counts as a failure of the store.
+func (breaker *BreakerStore) Do(fn func() error) error {
+	return breaker.call(fn)
+}
+
+func (breaker *BreakerStore) call(fn func() error) error {
+	if !breaker.Available() {
+		return ErrUnavailable
+	}
+	err := fn()
+	breaker.record(err)
+	return err
+}
+
+// record counts consecutive failures. A miss is a successful call.
+func (breaker *BreakerStore) record(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if err == nil || err == ErrMiss {
+		breaker.failures = 0
+		return
+	}
+	breaker.failures++
+	if !breaker.open && breaker.failures >= breaker.options.Threshold {
+		log.Error("Cache store failing, bypassing cache: ", err)
+		breaker.trip()
+	}
+}
+
+// trip opens the circuit and starts probing. The caller holds mu.
+func (breaker *BreakerStore) trip() {
+	breaker.open = true
+	storeAvailable.Set(0)
+	breakerTrips.Inc()
+	go breaker.recover()
+}
+
+func (breaker *BreakerStore) recover() {
+	for {
+		time.Sleep(breaker.options.ProbeInterval)
+		if err := breaker.probe(); err != nil {
+			log.Debug("Cache store still unavailable: ", err)
+			continue
+		}
+		if err := breaker.replay(); err != nil {
+			log.Warn("Cache store recovered but replaying invalidations failed: ", err)
+			continue
+		}
+
+		breaker.mu.Lock()
+		// Invalidations may have been queued while replaying.
+		if len(breaker.pendingKeys) > 0 || len(breaker.pendingTags) > 0 {
+			breaker.mu.Unlock()
+			continue
+		}
+		breaker.open = false
+		breaker.failures = 0
+		storeAvailable.Set(1)
+		breaker.mu.Unlock()
+		log.Info("Cache store recovered")
+		return
+	}
+}
+
+// replay applies the invalidations queued while the circuit was open.
+func (breaker *BreakerStore) replay() error {
+	breaker.mu.Lock()
+	keys := make([]string, 0, len(breaker.pendingKeys))
+	for key := range breaker.pendingKeys {
+		keys = append(keys, key)
+	}
+	tags := make([]string, 0, len(breaker.pendingTags))
+	for tag := range breaker.pendingTags {
+		tags = append(tags, tag)
+	}
+	breaker.mu.Unlock()
+
+	if err := breaker.store.Delete(keys...); err != nil {
+		return err
+	}
+	if err := breaker.store.DeleteTags(tags...); err != nil {
+		return err
+	}
+
+	breaker.mu.Lock()
+	for _, key := range keys {
+		delete(breaker.pendingKeys, key)
+	}
+	for _, tag := range tags {
+		delete(breaker.pendingTags, tag)
+	}
+	breaker.mu.Unlock()
+	return nil
+}
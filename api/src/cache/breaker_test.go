@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errDown = errors.New("connection refused")
+
+// flakyStore is a MemoryStore that fails every call while down is set.
+type flakyStore struct {
+	*MemoryStore
+	mu   sync.Mutex
+	down bool
+}
+
+func (store *flakyStore) setDown(down bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.down = down
+}
+
+func (store *flakyStore) ping() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.down {
+		return errDown
+	}
+	return nil
+}
+
+func (store *flakyStore) Get(key string) ([]byte, error) {
+	if err := store.ping(); err != nil {
+		return nil, err
+	}
+	return store.MemoryStore.Get(key)
+}
+
+func (store *flakyStore) Set(key string, value []byte, ttl time.Duration) error {
+	if err := store.ping(); err != nil {
+		return err
+	}
+	return store.MemoryStore.Set(key, value, ttl)
+}
+
+func (store *flakyStore) Delete(keys ...string) error {
+	if err := store.ping(); err != nil {
+		return err
+	}
+	return store.MemoryStore.Delete(keys...)
+}
+
+func TestBreakerBypassesFailingStore(t *testing.T) {
+	flaky := &flakyStore{MemoryStore: NewMemoryStore()}
+	breaker := NewBreakerStore(flaky, flaky.ping, BreakerOptions{Threshold: 2, ProbeInterval: 10 * time.Millisecond})
+	cache := New("test", breaker)
+
+	value := "v1"
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return item{Name: value}, nil
+	}
+
+	var got item
+	assert.Nil(t, cache.GetOrLoad(context.Background(), "key", &got, Options{}, load))
+	assert.Equal(t, 1, loads)
+
+	flaky.setDown(true)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cache.GetOrLoad(context.Background(), "other", &got, Options{}, load))
+	}
+	assert.False(t, breaker.Available())
+	assert.Equal(t, 4, loads)
+
+	// The value changes while the store is down; the invalidation is queued.
+	value = "v2"
+	assert.Nil(t, cache.Invalidate("key"))
+
+	flaky.setDown(false)
+	assert.Eventually(t, breaker.Available, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, cache.GetOrLoad(context.Background(), "key", &got, Options{}, load))
+	assert.Equal(t, "v2", got.Name)
+}
+
+func TestBreakerStartsOpenWhenProbeFails(t *testing.T) {
+	flaky := &flakyStore{MemoryStore: NewMemoryStore(), down: true}
+	breaker := NewBreakerStore(flaky, flaky.ping, BreakerOptions{Threshold: 1, ProbeInterval: 10 * time.Millisecond})
+	assert.False(t, breaker.Available())
+
+	_, err := breaker.Get("key")
+	assert.Equal(t, ErrUnavailable, err)
+
+	flaky.setDown(false)
+	assert.Eventually(t, breaker.Available, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,15 @@
+package cache
+
+// Invalidation lists the keys and tags dropped by a replica.
+type Invalidation struct {
+	Keys []string `json:"keys,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Bus broadcasts invalidations between replicas so each can drop the
+// matching entries from its in-process tier. Subscribers are not called for
+// invalidations published by their own replica.
+type Bus interface {
+	Publish(invalidation Invalidation) error
+	Subscribe(handler func(Invalidation))
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+type busMessage struct {
+	Origin string `json:"origin"`
+	Invalidation
+}
+
+// RedisBus carries invalidations over a Redis pub/sub channel. Messages are
+// best effort: a replica that misses one keeps its local entries until the
+// local tier TTL expires them.
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+	origin  string
+
+	mu       sync.RWMutex
+	handlers []func(Invalidation)
+}
+
+// NewRedisBus subscribes to channel and dispatches incoming invalidations
+// until the process exits. The client reconnects the subscription on its own.
+func NewRedisBus(client *redis.Client, channel string) *RedisBus {
+	bus := &RedisBus{
+		client:  client,
+		channel: channel,
+		origin:  uuid.NewV4().String(),
+	}
+	go bus.listen(client.Subscribe(channel))
+	return bus
+}
+
+func (bus *RedisBus) Publish(invalidation Invalidation) error {
+	data, err := json.Marshal(busMessage{Origin: bus.origin, Invalidation: invalidation})
+	if err != nil {
+		return err
+	}
+	return bus.client.Publish(bus.channel, data).Err()
+}
+
+func (bus *RedisBus) Subscribe(handler func(Invalidation)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers = append(bus.handlers, handler)
+}
+
+func (bus *RedisBus) listen(pubsub *redis.PubSub) {
+	for message := range pubsub.Channel() {
+		var decoded busMessage
+		if err := json.Unmarshal([]byte(message.Payload), &decoded); err != nil {
+			log.Error("Discarding undecodable invalidation: ", err)
+			continue
+		}
+		if decoded.Origin == bus.origin {
+			continue
+		}
+
+		bus.mu.RLock()
+		handlers := bus.handlers
+		bus.mu.RUnlock()
+		for _, handler := range handlers {
+			handler(decoded.Invalidation)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+var cacheHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Number of lookups served from the cache",
+	},
+	[]string{"cache"},
+)
+
+var cacheMisses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Number of lookups that called the loader",
+	},
+	[]string{"cache"},
+)
+
+var cacheBypasses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_bypasses_total",
+		Help: "Number of lookups served without the cache because the store failed",
+	},
+	[]string{"cache"},
+)
+
+var cacheStaleHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_stale_hits_total",
+		Help: "Number of lookups served a stale entry while it is refreshed",
+	},
+	[]string{"cache"},
+)
+
+var cacheLocalHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_local_hits_total",
+		Help: "Number of hits served by the in-process tier, included in cache_hits_total",
+	},
+	[]string{"cache"},
+)
+
+func init() {
+	prometheus.Register(cacheLocalHits)
+	prometheus.Register(cacheHits)
+	prometheus.Register(cacheMisses)
+	prometheus.Register(cacheBypasses)
+	prometheus.Register(cacheStaleHits)
+}
+
+const (
+	// loadTimeout bounds a load shared by concurrent callers, which is not
+	// cancelled along with the request that started it.
+	loadTimeout = 10 * time.Second
+	// refreshTimeout bounds a background refresh of a stale entry.
+	refreshTimeout = 10 * time.Second
+	// refreshBackoff is the delay before a failed refresh is retried, so an
+	// unavailable loader is not called on every request.
+	refreshBackoff = 5 * time.Second
+)
+
+// Options controls how a loaded value is cached.
+type Options struct {
+	// TTL is how long a loaded value is fresh. Zero keeps it until invalidated.
+	TTL time.Duration
+	// StaleTTL keeps an entry this much longer than TTL. During that window
+	// the stale value is served immediately and refreshed in the background,
+	// so it keeps being served while the loader is failing.
+	StaleTTL time.Duration
+	// Jitter adds a random duration up to this value to the TTL so entries
+	// written together do not expire together.
+	Jitter time.Duration
+	// NotFound is the loader error cached as a negative entry for
+	// NegativeTTL. Later lookups return the same error without loading.
+	// Negative caching is off when either is unset.
+	NotFound    error
+	NegativeTTL time.Duration
+	// Tags group the entry for InvalidateTags.
+	Tags []string
+
+	// save is cleared when the store failed the read, so the loaded value is
+	// not written back.
+	save bool
+}
+
+// entry is the envelope stored for each key. Negative entries have no value.
+// FreshUntil is the soft expiry in Unix nanoseconds; the store expires the
+// entry for good StaleTTL later. Zero means the entry never goes stale.
+type entry struct {
+	Value      json.RawMessage `json:"v,omitempty"`
+	Negative   bool            `json:"n,omitempty"`
+	FreshUntil int64           `json:"f,omitempty"`
+	// Tags are kept with the entry so the in-process tier can index entries
+	// it reads back from the shared store.
+	Tags []string `json:"t,omitempty"`
+}
+
+// Cache implements cache-aside loading on top of a Store. Values are stored
+// as JSON; concurrent misses on the same key share a single load. An optional
+// in-process LRU tier, see WithLocal, answers before the store.
+type Cache struct {
+	name  string
+	store Store
+	local *lru
+	bus   Bus
+	group singleflight.Group
+	// failedRefreshes holds the time of the last failed refresh per key.
+	failedRefreshes sync.Map
+	now             func() time.Time
+}
+
+// New returns a cache reporting metrics under name.
+func New(name string, store Store) *Cache {
+	return &Cache{
+		name:  name,
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// WithLocal adds an in-process LRU tier holding up to capacity entries for
+// at most ttl. Invalidations are published on bus and invalidations from
+// other replicas are applied to the tier; bus may be nil for a single
+// process, in which case ttl alone bounds how stale another writer can make
+// the tier.
+func (cache *Cache) WithLocal(capacity int, ttl time.Duration, bus Bus) *Cache {
+	cache.local = newLRU(capacity, ttl)
+	cache.bus = bus
+	if bus != nil {
+		bus.Subscribe(func(invalidation Invalidation) {
+			cache.local.delete(invalidation.Keys...)
+			cache.local.deleteTags(invalidation.Tags...)
+		})
+	}
+	return cache
+}
+
+// GetOrLoad decodes the cached value of key into dest. On a miss it calls
+// load, caches the result according to options and decodes it into dest.
+// Store failures are not returned: the value is loaded and not cached.
+func (cache *Cache) GetOrLoad(ctx context.Context, key string, dest interface{}, options Options, load func(ctx context.Context) (interface{}, error)) error {
+	options.save = true
+	if cached, ok := cache.lookup(ctx, key, &options, load); ok {
+		return cache.unwrap(cached, dest, options)
+	}
+
+	// The first caller loads on behalf of every concurrent caller of the same
+	// key, so the load must not fail when that caller goes away: it runs
+	// detached from the request, and each caller only stops waiting when its
+	// own context is done. Each caller decodes its own copy so values are
+	// never shared.
+	results := cache.group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(detach(ctx), loadTimeout)
+		defer cancel()
+		return cache.load(loadCtx, key, options, load)
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return result.Err
+		}
+		return cache.unwrap(result.Val.(entry), dest, options)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Lookup is GetOrLoad for values the caller produces itself on a miss, for
+// instance while streaming them to a client, so they are neither shared
+// with concurrent callers nor loaded in the background. It decodes the
+// cached value of key into dest and reports whether there was one; a stale
+// value counts and is refreshed with load. On a miss, save caches the value
+// once produced, as a load would have.
+func (cache *Cache) Lookup(ctx context.Context, key string, dest interface{}, options Options, load func(ctx context.Context) (interface{}, error)) (ok bool, save func(value interface{}), err error) {
+	options.save = true
+	if cached, ok := cache.lookup(ctx, key, &options, load); ok {
+		return true, nil, cache.unwrap(cached, dest, options)
+	}
+	return false, func(value interface{}) {
+		if _, err := cache.put(key, value, options); err != nil {
+			log.Error("Failed to encode cache entry ", key, ": ", err)
+		}
+	}, nil
+}
+
+// lookup reads key from the local tier, then from the store, and records
+// how it was answered. A stale entry is returned and refreshed with load.
+// When the store is failing, options is changed so nothing is written back.
+func (cache *Cache) lookup(ctx context.Context, key string, options *Options, load func(ctx context.Context) (interface{}, error)) (entry, bool) {
+	if cache.local != nil {
+		if cached, ok := cache.local.get(key, cache.now()); ok {
+			cacheHits.WithLabelValues(cache.name).Inc()
+			cacheLocalHits.WithLabelValues(cache.name).Inc()
+			recordStatus(ctx, StatusHit)
+			return cached, true
+		}
+	}
+
+	data, err := cache.store.Get(key)
+	switch {
+	case err == nil:
+		if cached, ok := cache.decode(key, data); ok {
+			if cached.FreshUntil != 0 && cache.now().UnixNano() >= cached.FreshUntil {
+				cacheStaleHits.WithLabelValues(cache.name).Inc()
+				recordStatus(ctx, StatusStale)
+				cache.refresh(key, *options, load)
+			} else {
+				cacheHits.WithLabelValues(cache.name).Inc()
+				recordStatus(ctx, StatusHit)
+				if cache.local != nil {
+					cache.local.set(key, cached, cache.now())
+				}
+			}
+			return cached, true
+		}
+		cacheMisses.WithLabelValues(cache.name).Inc()
+		recordStatus(ctx, StatusMiss)
+	case err == ErrMiss:
+		cacheMisses.WithLabelValues(cache.name).Inc()
+		recordStatus(ctx, StatusMiss)
+	default:
+		// The store is failing: serve from the loader and skip writing back.
+		log.Debug("Bypassing cache for ", key, ": ", err)
+		cacheBypasses.WithLabelValues(cache.name).Inc()
+		recordStatus(ctx, StatusBypass)
+		options.save = false
+	}
+	return entry{}, false
+}
+
+// detachedContext keeps the values of a context, such as request scoped
+// loggers, but not its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (cache *Cache) load(ctx context.Context, key string, options Options, load func(ctx context.Context) (interface{}, error)) (entry, error) {
+	value, err := load(ctx)
+	if err != nil {
+		if options.NotFound != nil && options.NegativeTTL > 0 && err == options.NotFound {
+			negative := entry{Negative: true}
+			cache.save(key, negative, options.NegativeTTL, options)
+			return negative, nil
+		}
+		return entry{}, err
+	}
+	return cache.put(key, value, options)
+}
+
+// put caches a loaded value and returns its entry.
+func (cache *Cache) put(key string, value interface{}, options Options) (entry, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return entry{}, err
+	}
+	loaded := entry{Value: data}
+	ttl := withJitter(options.TTL, options.Jitter)
+	if ttl > 0 && options.StaleTTL > 0 {
+		loaded.FreshUntil = cache.now().Add(ttl).UnixNano()
+		ttl += options.StaleTTL
+	}
+	cache.save(key, loaded, ttl, options)
+	return loaded, nil
+}
+
+// refresh reloads a stale entry in the background. The refresh shares the
+// singleflight group with foreground loads, and a failed refresh is not
+// retried for refreshBackoff.
+func (cache *Cache) refresh(key string, options Options, load func(ctx context.Context) (interface{}, error)) {
+	if failedAt, ok := cache.failedRefreshes.Load(key); ok && cache.now().Sub(failedAt.(time.Time)) < refreshBackoff {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		_, err, _ := cache.group.Do(key, func() (interface{}, error) {
+			return cache.load(ctx, key, options, load)
+		})
+		if err != nil {
+			log.Warn("Failed to refresh stale cache entry ", key, ": ", err)
+			cache.failedRefreshes.Store(key, cache.now())
+			return
+		}
+		cache.failedRefreshes.Delete(key)
+	}()
+}
+
+// save writes an entry. Failures are logged, not returned, because the value
+// was loaded successfully and the caller can still be served.
+func (cache *Cache) save(key string, cached entry, ttl time.Duration, options Options) {
+	if !options.save {
+		return
+	}
+	cached.Tags = options.Tags
+	data, _ := json.Marshal(cached)
+	if err := cache.store.Set(key, data, ttl); err != nil {
+		log.Error("Failed to write cache entry ", key, ": ", err)
+		return
+	}
+	for _, tag := range options.Tags {
+		if err := cache.store.Tag(tag, key); err != nil {
+			log.Error("Failed to tag cache entry ", key, ": ", err)
+		}
+	}
+	if cache.local != nil {
+		cache.local.set(key, cached, cache.now())
+	}
+}
+
+func (cache *Cache) decode(key string, data []byte) (entry, bool) {
+	var cached entry
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Error("Discarding undecodable cache entry ", key, ": ", err)
+		return entry{}, false
+	}
+	return cached, true
+}
+
+func (cache *Cache) unwrap(cached entry, dest interface{}, options Options) error {
+	if cached.Negative {
+		return options.NotFound
+	}
+	return json.Unmarshal(cached.Value, dest)
+}
+
+// Invalidate drops the given keys.
+func (cache *Cache) Invalidate(keys ...string) error {
+	if cache.local != nil {
+		cache.local.delete(keys...)
+	}
+	err := cache.store.Delete(keys...)
+	cache.publish(Invalidation{Keys: keys})
+	return err
+}
+
+// InvalidateTags drops every key carrying one of the tags.
+func (cache *Cache) InvalidateTags(tags ...string) error {
+	if cache.local != nil {
+		cache.local.deleteTags(tags...)
+	}
+	err := cache.store.DeleteTags(tags...)
+	cache.publish(Invalidation{Tags: tags})
+	return err
+}
+
+// publish tells the other replicas to drop entries from their local tier.
+// It runs after the shared store was updated so they cannot reload the old
+// value from it.
+func (cache *Cache) publish(invalidation Invalidation) {
+	if cache.bus == nil {
+		return
+	}
+	if err := cache.bus.Publish(invalidation); err != nil {
+		log.Error("Failed to publish cache invalidation: ", err)
+	}
+}
+
+func withJitter(ttl, jitter time.Duration) time.Duration {
+	if ttl <= 0 || jitter <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(int64(jitter)))
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+// testClock is a manually advanced clock, safe for background refreshes.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func (clock *testClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+}
+
+func TestGetOrLoadCachesValue(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return item{Name: "pizza"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		var value item
+		err := cache.GetOrLoad(context.Background(), "key", &value, Options{}, load)
+		assert.Nil(t, err)
+		assert.Equal(t, "pizza", value.Name)
+	}
+	assert.Equal(t, 1, loads)
+}
+
+func TestGetOrLoadExpiresAfterTTL(t *testing.T) {
+	store := NewMemoryStore()
+	clock := &testClock{now: time.Now()}
+	store.now = clock.Now
+	cache := New("test", store)
+
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return item{Name: "pizza"}, nil
+	}
+	options := Options{TTL: time.Minute, Jitter: 10 * time.Second}
+
+	var value item
+	cache.GetOrLoad(context.Background(), "key", &value, options, load)
+	clock.Advance(59 * time.Second)
+	cache.GetOrLoad(context.Background(), "key", &value, options, load)
+	assert.Equal(t, 1, loads)
+
+	clock.Advance(11 * time.Second)
+	cache.GetOrLoad(context.Background(), "key", &value, options, load)
+	assert.Equal(t, 2, loads)
+}
+
+func TestGetOrLoadCoalescesConcurrentMisses(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return item{Name: "pizza"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var value item
+			assert.Nil(t, cache.GetOrLoad(context.Background(), "key", &value, Options{}, load))
+			assert.Equal(t, "pizza", value.Name)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestGetOrLoadSurvivesCancelledFirstCaller(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return item{Name: "pizza"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		var value item
+		firstErr <- cache.GetOrLoad(first, "key", &value, Options{}, load)
+	}()
+	<-started
+
+	second := make(chan item)
+	go func() {
+		var value item
+		assert.Nil(t, cache.GetOrLoad(context.Background(), "key", &value, Options{}, load))
+		second <- value
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The first client disconnects: it stops waiting, the load goes on.
+	cancel()
+	assert.Equal(t, context.Canceled, <-firstErr)
+	close(release)
+	assert.Equal(t, "pizza", (<-second).Name)
+}
+
+func TestLookup(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	load := func(ctx context.Context) (interface{}, error) {
+		return item{Name: "refreshed"}, nil
+	}
+
+	var value item
+	ctx, recorder := WithStatusRecorder(context.Background())
+	ok, save, err := cache.Lookup(ctx, "key", &value, Options{}, load)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, StatusMiss, recorder.Status())
+	save(item{Name: "pizza"})
+
+	ctx, recorder = WithStatusRecorder(context.Background())
+	ok, _, err = cache.Lookup(ctx, "key", &value, Options{}, load)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "pizza", value.Name)
+	assert.Equal(t, StatusHit, recorder.Status())
+
+	// What Lookup saved is what GetOrLoad would have loaded.
+	assert.Nil(t, cache.GetOrLoad(context.Background(), "key", &value, Options{}, load))
+	assert.Equal(t, "pizza", value.Name)
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore()
+	store.Set("key", []byte("value"), time.Minute)
+
+	value, err := store.Take("key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(value))
+	_, err = store.Take("key")
+	assert.Equal(t, ErrMiss, err)
+	_, err = store.Get("key")
+	assert.Equal(t, ErrMiss, err)
+}
+
+func TestGetOrLoadNegativeCaching(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	errNotFound := errors.New("not found")
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return nil, errNotFound
+	}
+	options := Options{NotFound: errNotFound, NegativeTTL: time.Minute}
+
+	var value item
+	assert.Equal(t, errNotFound, cache.GetOrLoad(context.Background(), "key", &value, options, load))
+	assert.Equal(t, errNotFound, cache.GetOrLoad(context.Background(), "key", &value, options, load))
+	assert.Equal(t, 1, loads)
+
+	// Other errors are not cached.
+	errBoom := errors.New("boom")
+	failing := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return nil, errBoom
+	}
+	assert.Equal(t, errBoom, cache.GetOrLoad(context.Background(), "other", &value, options, failing))
+	assert.Equal(t, errBoom, cache.GetOrLoad(context.Background(), "other", &value, options, failing))
+	assert.Equal(t, 3, loads)
+}
+
+func TestInvalidateTags(t *testing.T) {
+	cache := New("test", NewMemoryStore())
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return item{Name: "pizza"}, nil
+	}
+
+	var value item
+	cache.GetOrLoad(context.Background(), "a", &value, Options{Tags: []string{"list"}}, load)
+	cache.GetOrLoad(context.Background(), "b", &value, Options{Tags: []string{"list"}}, load)
+	cache.GetOrLoad(context.Background(), "c", &value, Options{}, load)
+	assert.Equal(t, 3, loads)
+
+	assert.Nil(t, cache.InvalidateTags("list"))
+	cache.GetOrLoad(context.Background(), "a", &value, Options{Tags: []string{"list"}}, load)
+	cache.GetOrLoad(context.Background(), "b", &value, Options{Tags: []string{"list"}}, load)
+	cache.GetOrLoad(context.Background(), "c", &value, Options{}, load)
+	assert.Equal(t, 5, loads)
+
+	assert.Nil(t, cache.Invalidate("c"))
+	cache.GetOrLoad(context.Background(), "c", &value, Options{}, load)
+	assert.Equal(t, 6, loads)
+}
+
+func TestGetOrLoadServesStaleWhileRefreshing(t *testing.T) {
+	store := NewMemoryStore()
+	clock := &testClock{now: time.Now()}
+	store.now = clock.Now
+	cache := New("test", store)
+	cache.now = clock.Now
+
+	var mu sync.Mutex
+	value, failing := "v1", false
+	load := func(ctx context.Context) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return nil, errors.New("store down")
+		}
+		return item{Name: value}, nil
+	}
+	options := Options{TTL: time.Minute, StaleTTL: time.Hour}
+
+	var got item
+	ctx, recorder := WithStatusRecorder(context.Background())
+	cache.GetOrLoad(ctx, "key", &got, options, load)
+	assert.Equal(t, StatusMiss, recorder.Status())
+
+	// Past the TTL the loader fails, but the stale value is still served.
+	mu.Lock()
+	value, failing = "v2", true
+	mu.Unlock()
+	clock.Advance(2 * time.Minute)
+	ctx, recorder = WithStatusRecorder(context.Background())
+	assert.Nil(t, cache.GetOrLoad(ctx, "key", &got, options, load))
+	assert.Equal(t, "v1", got.Name)
+	assert.Equal(t, StatusStale, recorder.Status())
+
+	// Once the loader recovers, the background refresh replaces the entry.
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	clock.Advance(refreshBackoff)
+	cache.GetOrLoad(context.Background(), "key", &got, options, load)
+	assert.Eventually(t, func() bool {
+		ctx, recorder := WithStatusRecorder(context.Background())
+		var fresh item
+		cache.GetOrLoad(ctx, "key", &fresh, options, load)
+		return recorder.Status() == StatusHit && fresh.Name == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	// Past the stale window the entry is gone.
+	clock.Advance(2 * time.Hour)
+	ctx, recorder = WithStatusRecorder(context.Background())
+	cache.GetOrLoad(ctx, "key", &got, options, load)
+	assert.Equal(t, StatusMiss, recorder.Status())
+}
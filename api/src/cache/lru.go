@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type lruItem struct {
+	key       string
+	entry     entry
+	expiresAt time.Time
+}
+
+// lru is the bounded in-process tier of a Cache. It holds decoded entries
+// and indexes them by tag so tag invalidations can be applied locally.
+type lru struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List
+	tags     map[string]map[string]bool
+}
+
+func newLRU(capacity int, ttl time.Duration) *lru {
+	return &lru{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		tags:     make(map[string]map[string]bool),
+	}
+}
+
+func (cache *lru) get(key string, now time.Time) (entry, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.items[key]
+	if !ok {
+		return entry{}, false
+	}
+	item := element.Value.(*lruItem)
+	if !now.Before(item.expiresAt) {
+		cache.remove(element)
+		return entry{}, false
+	}
+	cache.order.MoveToFront(element)
+	return item.entry, true
+}
+
+// set stores an entry for the tier TTL, or until the entry goes stale if
+// that is sooner. Stale entries are only served from the shared store.
+func (cache *lru) set(key string, cached entry, now time.Time) {
+	expiresAt := now.Add(cache.ttl)
+	if cached.FreshUntil != 0 && cached.FreshUntil < expiresAt.UnixNano() {
+		expiresAt = time.Unix(0, cached.FreshUntil)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.items[key]; ok {
+		cache.remove(element)
+	}
+	cache.items[key] = cache.order.PushFront(&lruItem{key: key, entry: cached, expiresAt: expiresAt})
+	for _, tag := range cached.Tags {
+		if cache.tags[tag] == nil {
+			cache.tags[tag] = make(map[string]bool)
+		}
+		cache.tags[tag][key] = true
+	}
+	for cache.order.Len() > cache.capacity {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *lru) delete(keys ...string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := cache.items[key]; ok {
+			cache.remove(element)
+		}
+	}
+}
+
+func (cache *lru) deleteTags(tags ...string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range cache.tags[tag] {
+			if element, ok := cache.items[key]; ok {
+				cache.remove(element)
+			}
+		}
+		delete(cache.tags, tag)
+	}
+}
+
+// remove drops an element and its tag memberships. The caller holds mu.
+func (cache *lru) remove(element *list.Element) {
+	item := element.Value.(*lruItem)
+	cache.order.Remove(element)
+	delete(cache.items, item.key)
+	for _, tag := range item.entry.Tags {
+		delete(cache.tags[tag], item.key)
+		if len(cache.tags[tag]) == 0 {
+			delete(cache.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testBus delivers invalidations synchronously to the other subscribers.
+type testBus struct {
+	mu       sync.Mutex
+	handlers map[*testBusClient]func(Invalidation)
+}
+
+type testBusClient struct {
+	bus *testBus
+}
+
+func (client *testBusClient) Publish(invalidation Invalidation) error {
+	client.bus.mu.Lock()
+	defer client.bus.mu.Unlock()
+	for other, handler := range client.bus.handlers {
+		if other != client {
+			handler(invalidation)
+		}
+	}
+	return nil
+}
+
+func (client *testBusClient) Subscribe(handler func(Invalidation)) {
+	client.bus.mu.Lock()
+	defer client.bus.mu.Unlock()
+	client.bus.handlers[client] = handler
+}
+
+func TestLocalTierIsKeptCoherentAcrossReplicas(t *testing.T) {
+	shared := NewMemoryStore()
+	bus := &testBus{handlers: make(map[*testBusClient]func(Invalidation))}
+	replicaA := New("test", shared).WithLocal(10, time.Minute, &testBusClient{bus: bus})
+	replicaB := New("test", shared).WithLocal(10, time.Minute, &testBusClient{bus: bus})
+
+	value := "v1"
+	load := func(ctx context.Context) (interface{}, error) {
+		return item{Name: value}, nil
+	}
+	options := Options{Tags: []string{"list"}}
+
+	var got item
+	replicaA.GetOrLoad(context.Background(), "key", &got, options, load)
+	ctx, recorder := WithStatusRecorder(context.Background())
+	replicaB.GetOrLoad(ctx, "key", &got, options, load)
+	assert.Equal(t, StatusHit, recorder.Status())
+
+	// Replica B now answers from its local tier, even if the shared entry
+	// changes behind its back.
+	shared.Delete("key")
+	replicaB.GetOrLoad(context.Background(), "key", &got, options, load)
+	assert.Equal(t, "v1", got.Name)
+
+	// A tag invalidation on replica A reaches B's local tier.
+	value = "v2"
+	assert.Nil(t, replicaA.InvalidateTags("list"))
+	replicaB.GetOrLoad(context.Background(), "key", &got, options, load)
+	assert.Equal(t, "v2", got.Name)
+
+	value = "v3"
+	assert.Nil(t, replicaA.Invalidate("key"))
+	replicaB.GetOrLoad(context.Background(), "key", &got, options, load)
+	assert.Equal(t, "v3", got.Name)
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRU(2, time.Minute)
+	now := time.Now()
+
+	cache.set("a", entry{Tags: []string{"list"}}, now)
+	cache.set("b", entry{}, now)
+	cache.get("a", now)
+	cache.set("c", entry{}, now)
+
+	_, ok := cache.get("b", now)
+	assert.False(t, ok)
+	_, ok = cache.get("a", now)
+	assert.True(t, ok)
+
+	cache.deleteTags("list")
+	_, ok = cache.get("a", now)
+	assert.False(t, ok)
+
+	_, ok = cache.get("c", now.Add(time.Minute))
+	assert.False(t, ok)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// Status describes how the cache answered a lookup. The values are the ones
+// sent in the X-Cache response header.
+type Status string
+
+const (
+	StatusHit    Status = "HIT"
+	StatusMiss   Status = "MISS"
+	StatusStale  Status = "STALE"
+	StatusBypass Status = "BYPASS"
+)
+
+// statusRank orders statuses so a recorder reports the least fresh outcome
+// when a request made several lookups.
+var statusRank = map[Status]int{
+	StatusHit:    1,
+	StatusMiss:   2,
+	StatusBypass: 3,
+	StatusStale:  4,
+}
+
+type statusKey struct{}
+
+// StatusRecorder collects the outcome of the lookups made with a context
+// returned by WithStatusRecorder.
+type StatusRecorder struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func WithStatusRecorder(ctx context.Context) (context.Context, *StatusRecorder) {
+	recorder := &StatusRecorder{}
+	return context.WithValue(ctx, statusKey{}, recorder), recorder
+}
+
+// Status returns the least fresh outcome recorded, or "" if the cache was
+// not consulted.
+func (recorder *StatusRecorder) Status() Status {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.status
+}
+
+func recordStatus(ctx context.Context, status Status) {
+	recorder, ok := ctx.Value(statusKey{}).(*StatusRecorder)
+	if !ok {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if statusRank[status] > statusRank[recorder.status] {
+		recorder.status = status
+	}
+}
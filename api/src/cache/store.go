@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by a Store when the key is absent or expired.
+var ErrMiss = errors.New("cache: miss")
+
+// Store holds encoded cache entries. Tags group keys so they can be dropped
+// together; a tag outlives the keys it references, stale members are
+// harmless since deleting a missing key is a no-op.
+type Store interface {
+	Get(key string) ([]byte, error)
+	// Take gets a key and deletes it at once, so among concurrent callers
+	// only one gets the value; the others get ErrMiss.
+	Take(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(keys ...string) error
+	Tag(tag string, keys ...string) error
+	// DeleteTags drops every key carrying one of the tags, and the tags.
+	DeleteTags(tags ...string) error
+}
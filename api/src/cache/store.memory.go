@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps entries in process memory. Expired entries are dropped
+// lazily when read.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	tags    map[string]map[string]bool
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		tags:    make(map[string]map[string]bool),
+		now:     time.Now,
+	}
+}
+
+func (store *MemoryStore) Get(key string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	if !entry.expiresAt.IsZero() && !store.now().Before(entry.expiresAt) {
+		delete(store.entries, key)
+		return nil, ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (store *MemoryStore) Take(key string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	delete(store.entries, key)
+	if !entry.expiresAt.IsZero() && !store.now().Before(entry.expiresAt) {
+		return nil, ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (store *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = store.now().Add(ttl)
+	}
+	store.entries[key] = entry
+	return nil
+}
+
+func (store *MemoryStore) Delete(keys ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, key := range keys {
+		delete(store.entries, key)
+	}
+	return nil
+}
+
+func (store *MemoryStore) Tag(tag string, keys ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := tagPrefix + tag
+	if store.tags[key] == nil {
+		store.tags[key] = make(map[string]bool)
+	}
+	for _, member := range keys {
+		store.tags[key][member] = true
+	}
+	return nil
+}
+
+func (store *MemoryStore) DeleteTags(tags ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, tag := range tags {
+		for member := range store.tags[tagPrefix+tag] {
+			delete(store.entries, member)
+		}
+		delete(store.tags, tagPrefix+tag)
+	}
+	return nil
+}
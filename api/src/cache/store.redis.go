@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const tagPrefix = "tag:"
+
+// RedisStore keeps entries in Redis. Tags are Redis sets named tag:<tag>.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (store *RedisStore) Get(key string) ([]byte, error) {
+	value, err := store.client.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return value, err
+}
+
+// Take runs GET and DEL in a transaction, as GETDEL needs Redis 6.2.
+func (store *RedisStore) Take(key string) ([]byte, error) {
+	var get *redis.StringCmd
+	_, err := store.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return get.Bytes()
+}
+
+func (store *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	return store.client.Set(key, value, ttl).Err()
+}
+
+func (store *RedisStore) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return store.client.Del(keys...).Err()
+}
+
+func (store *RedisStore) Tag(tag string, keys ...string) error {
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	return store.client.SAdd(tagPrefix+tag, members...).Err()
+}
+
+func (store *RedisStore) DeleteTags(tags ...string) error {
+	keys := make([]string, 0)
+	for _, tag := range tags {
+		members, err := store.client.SMembers(tagPrefix + tag).Result()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, members...)
+		keys = append(keys, tagPrefix+tag)
+	}
+	return store.Delete(keys...)
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"microservice/src/middlewares"
+	"microservice/src/models"
+	"microservice/src/repository"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiKeyNamePattern keeps names readable as the author of recipes, which
+// show them as "apikey:<name>".
+var apiKeyNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+type APIKeysController struct {
+	repository repository.APIKeyRepository
+}
+
+func NewAPIKeysController(repository repository.APIKeyRepository) *APIKeysController {
+	return &APIKeysController{
+		repository: repository,
+	}
+}
+
+// NewAPIKey godoc
+// @Summary Issue an API key
+// @Tags apikey
+// @Description create a key for a machine client, sent in the X-API-Key header; the key is only returned now. Names are never reused, even once revoked
+// @ID new-api-key
+// @Accept  json
+// @Produce  json
+// @Param message body models.APIKeyRequest true "Name, role and scopes"
+// @Success 201 {object} models.NewAPIKey
+// @Failure 400,403,409 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /api-keys [post]
+func (controller *APIKeysController) NewAPIKey(c *gin.Context) {
+	var request models.APIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !apiKeyNamePattern.MatchString(request.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit"})
+		return
+	}
+
+	role := request.Role
+	if role == "" {
+		role = models.DefaultRole
+	}
+	granted := middlewares.ScopesForRole(role)
+	if len(granted) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role " + role})
+		return
+	}
+	scopes := request.Scopes
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	for _, scope := range scopes {
+		if !contains(granted, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role " + role + " does not grant scope " + scope})
+			return
+		}
+	}
+
+	key, prefix, hash, err := middlewares.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	apiKey := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Role:      role,
+		Scopes:    scopes,
+		CreatedBy: middlewares.Claims(c).Username,
+		CreatedAt: time.Now(),
+	}
+	err = controller.repository.Create(c.Request.Context(), apiKey)
+	if err == repository.ErrAPIKeyExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, models.NewAPIKey{APIKey: apiKey, Key: key})
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Tags apikey
+// @Description get every API key, including revoked ones, newest first
+// @ID get-api-keys
+// @Produce  json
+// @Success 200 {object} models.APIKeyList
+// @Failure 403 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /api-keys [get]
+func (controller *APIKeysController) ListAPIKeys(c *gin.Context) {
+	keys, err := controller.repository.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", privateCacheControl)
+	c.JSON(http.StatusOK, models.APIKeyList{Keys: keys})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Tags apikey
+// @Description stop accepting an API key; it stays listed
+// @ID revoke-api-key
+// @Produce  json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 400,403,404 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /api-keys/{id} [delete]
+func (controller *APIKeysController) RevokeAPIKey(c *gin.Context) {
+	objectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	key, err := controller.repository.Revoke(c.Request.Context(), objectId, time.Now())
+	if err == repository.ErrAPIKeyNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
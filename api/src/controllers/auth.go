@@ -1,32 +1,62 @@
 package controllers
 
 import (
-	"context"
-	"crypto/sha256"
+	"fmt"
+	"microservice/src/cache"
+	"microservice/src/lockout"
+	"microservice/src/mail"
+	"microservice/src/middlewares"
 	"microservice/src/models"
+	"microservice/src/oidc"
+	"microservice/src/password"
+	"microservice/src/repository"
+	"microservice/src/session"
+	"microservice/src/signing"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const accessTokenTTL = 10 * time.Minute
+
 type AuthController struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	keys       *signing.KeySet
+	users      repository.UserRepository
+	mailer     mail.Sender
+	verifyURL  string
+	sessions   session.Store
+	refreshTTL time.Duration
+	oidc       *oidc.Provider
+	oidcStates cache.Store
+
+	usernameAttempts lockout.Limiter
+	addressAttempts  lockout.Limiter
 }
 
-func NewAuthController(ctx context.Context, collection *mongo.Collection) *AuthController {
+// NewAuthController signs in users from users, issuing tokens signed with
+// keys. Sign up emails verification links, pointing at verifyURL, through
+// mailer.
+func NewAuthController(keys *signing.KeySet, users repository.UserRepository, mailer mail.Sender, verifyURL string) *AuthController {
 	return &AuthController{
-		collection: collection,
-		ctx:        ctx,
+		keys:      keys,
+		users:     users,
+		mailer:    mailer,
+		verifyURL: verifyURL,
 	}
 }
 
+// WithSessions has sign in return refresh tokens, kept in sessions for
+// refreshTTL after their last use, and enables sign out.
+func (controller *AuthController) WithSessions(sessions session.Store, refreshTTL time.Duration) *AuthController {
+	controller.sessions = sessions
+	controller.refreshTTL = refreshTTL
+	return controller
+}
+
 // SignIn godoc
 // @Tags auth
 // @Summary User sign in
@@ -34,10 +64,9 @@ func NewAuthController(ctx context.Context, collection *mongo.Collection) *AuthC
 // @Accept  json
 // @Produce  json
 // @Param message body models.User true "User Info"
-// @Success 200 {object} models.Recipe
-// @Header 200 {string} Token "qwerty"
-// @Failure 400,404 {object} httputil.HTTPError
-// @Failure 500 {object} httputil.HTTPError
+// @Success 200 {object} models.JWTOutput
+// @Failure 400,401,403,429 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
 // @Router /signin [post]
 func (controller *AuthController) SignIn(c *gin.Context) {
 	var user models.User
@@ -46,87 +75,322 @@ func (controller *AuthController) SignIn(c *gin.Context) {
 		return
 	}
 
-	log.Debug("Username: ", user.Username)
-	log.Debug("Password: ", user.Password)
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
 
-	h := sha256.New()
+	if !controller.allowSignIn(c, user.Username) {
+		return
+	}
 
-	cur := controller.collection.FindOne(controller.ctx, bson.M{
-		"username": user.Username,
-		"password": string(h.Sum([]byte(user.Password))),
-	})
-	if cur.Err() != nil {
+	stored, err := controller.users.FindByUsername(c.Request.Context(), user.Username)
+	if err != nil && err != repository.ErrUserNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err == repository.ErrUserNotFound {
+		// Hash anyway so unknown usernames take as long as wrong passwords.
+		stored.Password = unknownUserHash()
+	}
+	ok, rehash, err := password.Verify(user.Password, stored.Password)
+	if err != nil {
+		log.Error("Failed to verify password of ", user.Username, ": ", err)
+	}
+	if !ok || stored.Username == "" {
+		controller.signInFailed(c, user.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
+	controller.clearFailures(c, user.Username)
+	if stored.Email != "" && !stored.EmailVerified {
+		authEvents.WithLabelValues("signin", "unverified").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+		return
+	}
+	if rehash {
+		controller.rehash(c, user)
+	}
+
+	var sessionID, refreshToken string
+	if controller.sessions != nil {
+		sessionID, refreshToken, err = controller.startSession(c, stored.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	authEvents.WithLabelValues("signin", "success").Inc()
+	controller.writeTokens(c, stored, sessionID, refreshToken)
+}
+
+// startSession opens a session for username and returns its ID and first
+// refresh token.
+func (controller *AuthController) startSession(c *gin.Context, username string) (string, string, error) {
+	sessionID, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	err = controller.sessions.Start(c.Request.Context(), session.Session{ID: sessionID, Username: username}, hashToken(refreshToken), controller.refreshTTL)
+	return sessionID, refreshToken, err
+}
 
-	expirationTime := time.Now().Add(10 * time.Minute)
-	claims := &models.Claims{
+// writeTokens responds with an access token for user in the session, along
+// with refreshToken.
+func (controller *AuthController) writeTokens(c *gin.Context, user models.User, sessionID, refreshToken string) {
+	if user.Role == "" {
+		user.Role = models.DefaultRole
+	}
+	jwtOutput, err := controller.issueToken(&models.Claims{
 		Username: user.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+		Role:     user.Role,
+		Scope:    strings.Join(middlewares.ScopesForRole(user.Role), " "),
+		Session:  sessionID,
+	}, accessTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	jwtOutput.RefreshToken = refreshToken
+	c.JSON(http.StatusOK, jwtOutput)
+}
+
+// rehash replaces a legacy or outdated password hash now that the plain
+// password is at hand. Sign-in goes ahead if the update fails; the next
+// one retries it.
+func (controller *AuthController) rehash(c *gin.Context, user models.User) {
+	hash, err := password.Hash(user.Password)
+	if err == nil {
+		err = controller.users.UpdatePassword(c.Request.Context(), user.Username, hash)
+	}
+	if err != nil {
+		log.Error("Failed to rehash password of ", user.Username, ": ", err)
+	}
+}
+
+var (
+	unknownUserOnce sync.Once
+	unknownUser     string
+)
+
+// unknownUserHash is verified against when a username does not exist, so
+// that sign-in timing does not reveal which usernames are taken.
+func unknownUserHash() string {
+	unknownUserOnce.Do(func() {
+		unknownUser, _ = password.Hash("unknown user")
+	})
+	return unknownUser
+}
+
+const (
+	defaultScopedTokenTTL = time.Hour
+	maxScopedTokenTTL     = 24 * time.Hour
+)
+
+// ScopedToken godoc
+// @Tags auth
+// @Summary Mint a down-scoped token
+// @Description issue a token restricted to some of the scopes of the caller's token, e.g. for CI scripts. It belongs to the caller's session and expires with the caller's token at the latest; API keys and minted tokens cannot mint more.
+// @Accept  json
+// @Produce  json
+// @Param message body models.ScopedTokenRequest true "Scopes and lifetime in seconds (default 3600, max 86400)"
+// @Success 200 {object} models.JWTOutput
+// @Failure 400,401,403 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /tokens [post]
+func (controller *AuthController) ScopedToken(c *gin.Context) {
+	var request models.ScopedTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(request.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+
+	ttl := defaultScopedTokenTTL
+	if request.ExpiresIn != 0 {
+		ttl = time.Duration(request.ExpiresIn) * time.Second
+		if ttl <= 0 || ttl > maxScopedTokenTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(maxScopedTokenTTL/time.Second))})
+			return
+		}
+	}
+
+	// Minted tokens could otherwise be renewed from one another forever,
+	// and outlive a revoked API key.
+	claims := middlewares.Claims(c)
+	if claims.Minted || strings.HasPrefix(claims.Username, models.APIKeyUserPrefix) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tokens can only be minted from a sign in token"})
+		return
+	}
+	if claims.ExpiresAt != 0 {
+		if remaining := time.Until(time.Unix(claims.ExpiresAt, 0)); remaining < ttl {
+			ttl = remaining
+		}
+	}
+
+	for _, scope := range request.Scopes {
+		if !isKnownScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+		if !claims.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing scope " + scope, "scope": scope})
+			return
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	jwtOutput, err := controller.issueToken(&models.Claims{
+		Username: claims.Username,
+		Role:     claims.Role,
+		Scope:    strings.Join(request.Scopes, " "),
+		Session:  claims.Session,
+		Minted:   true,
+	}, ttl)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, jwtOutput)
+}
 
-	jwtOutput := models.JWTOutput{
+func isKnownScope(scope string) bool {
+	for _, known := range models.Scopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// issueToken signs claims into an access token valid for ttl, with a new
+// token ID.
+func (controller *AuthController) issueToken(claims *models.Claims, ttl time.Duration) (models.JWTOutput, error) {
+	id, err := randomToken()
+	if err != nil {
+		return models.JWTOutput{}, err
+	}
+	expirationTime := time.Now().Add(ttl)
+	claims.Id = id
+	claims.ExpiresAt = expirationTime.Unix()
+
+	tokenString, err := controller.keys.Sign(claims)
+	if err != nil {
+		return models.JWTOutput{}, err
+	}
+	return models.JWTOutput{
 		Token:   tokenString,
 		Expires: expirationTime,
-	}
-	c.JSON(http.StatusOK, jwtOutput)
+	}, nil
 }
 
 // RefreshToken godoc
 // @Tags auth
 // @Summary Refresh auth token
-// @Description Refresh auth token
+// @Description exchange a refresh token for a new access token and the next refresh token; a refresh token works once, and using it again signs the session out
 // @Accept  json
 // @Produce  json
-// @Param message body models.User true "User Info"
-// @Success 200 {object} models.Recipe
-// @Header 200 {string} Token "qwerty"
-// @Failure 400,404 {object} httputil.HTTPError
-// @Failure 500 {object} httputil.HTTPError
-// @Router /refresh-token [get]
+// @Param message body models.RefreshRequest true "Refresh token"
+// @Success 200 {object} models.JWTOutput
+// @Failure 400,401 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Router /refresh [post]
 func (controller *AuthController) RefreshToken(c *gin.Context) {
-	tokenValue := c.GetHeader("Authorization")
-	claims := &models.Claims{}
-	tkn, err := jwt.ParseWithClaims(tokenValue, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	var request models.RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if controller.sessions == nil || controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Sessions store is not configured"})
+		return
+	}
+
+	refreshToken, err := randomToken()
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	current, err := controller.sessions.Rotate(c.Request.Context(), hashToken(request.RefreshToken), hashToken(refreshToken), controller.refreshTTL)
+	if err == session.ErrReused {
+		authEvents.WithLabelValues("refresh", "reused").Inc()
+		log.Warn("Refresh token reused, revoked session of ", current.Username)
+	}
+	if err == session.ErrNotFound || err == session.ErrReused {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
-	if !tkn.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if time.Until(time.Unix(claims.ExpiresAt, 0)) > 30*time.Second {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is not expired yet"})
+	// The role may have changed since sign in.
+	user, err := controller.users.FindByUsername(c.Request.Context(), current.Username)
+	if err == repository.ErrUserNotFound {
+		controller.sessions.Revoke(c.Request.Context(), current.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": session.ErrNotFound.Error()})
 		return
 	}
-
-	log.Info("Refresh token")
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(os.Getenv("JWT_SECRET"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	authEvents.WithLabelValues("refresh", "success").Inc()
+	controller.writeTokens(c, user, current.ID, refreshToken)
+}
 
-	jwtOutput := models.JWTOutput{
-		Token:   tokenString,
-		Expires: expirationTime,
+// Logout godoc
+// @Tags auth
+// @Summary Sign out
+// @Description revoke the access token and, for tokens from sign in, the whole session with its refresh token
+// @Produce  json
+// @Success 200 {object} object
+// @Failure 401 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /logout [post]
+func (controller *AuthController) Logout(c *gin.Context) {
+	if controller.sessions == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Sessions store is not configured"})
+		return
 	}
-	c.JSON(http.StatusOK, jwtOutput)
+
+	// A minted token only signs itself out; it must not end the session of
+	// the sign in it came from.
+	claims := middlewares.Claims(c)
+	if claims.Session != "" && !claims.Minted {
+		if err := controller.sessions.Revoke(c.Request.Context(), claims.Session); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if claims.Id != "" {
+		if err := controller.sessions.Deny(c.Request.Context(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out"})
+}
+
+// JWKS godoc
+// @Tags auth
+// @Summary Token verification keys
+// @Description the public keys access tokens are signed with, as a JSON Web Key Set; tokens name their key in the kid header
+// @Produce  json
+// @Success 200 {object} models.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (controller *AuthController) JWKS(c *gin.Context) {
+	// Upcoming keys are published ahead of use, so verifiers can cache the
+	// set for a while.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, controller.keys.JWKS())
 }
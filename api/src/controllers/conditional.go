@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// publicCacheControl lets nginx and browsers reuse a response briefly,
+	// then revalidate it with the ETag. Public recipe reads do not depend on
+	// the caller.
+	publicCacheControl = "public, max-age=10, must-revalidate"
+	// privateCacheControl keeps responses to signed-in users out of shared
+	// caches, and has the browser revalidate them every time.
+	privateCacheControl = "private, no-cache"
+)
+
+// etagOf returns a strong ETag for a response body, so any change to the
+// encoded recipes yields a new tag.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hashETag(sum[:])
+}
+
+// hashETag formats the SHA-256 sum of a body as its ETag.
+func hashETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// versionETag is the ETag of a single recipe. Every write bumps the version,
+// and clients send the tag back in If-Match to make conditional writes.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+var errInvalidIfMatch = errors.New(`If-Match must be a single recipe ETag, such as "3", or *`)
+
+// anyVersion is the version expected by If-Match: *, which only requires
+// the recipe to exist. Writes replace it with the current version.
+const anyVersion int64 = -1
+
+// expectedVersion returns the recipe version a write is conditioned on,
+// taken from If-Match or else from the request body. ok is false when the
+// client sent neither.
+func expectedVersion(c *gin.Context, bodyVersion int64) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return bodyVersion, bodyVersion > 0, nil
+	}
+	if header == "*" {
+		return anyVersion, true, nil
+	}
+	unquoted, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, false, errInvalidIfMatch
+	}
+	version, err = strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 0 {
+		return 0, false, errInvalidIfMatch
+	}
+	return version, true, nil
+}
+
+// writeConditional sends body with its validators, or an empty 304 Not
+// Modified when the client's copy is still current. lastModified may be
+// zero when the resource has no meaningful modification time.
+func writeConditional(c *gin.Context, body []byte, etag string, lastModified time.Time, cacheControl string) {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since as in RFC 7232
+// section 6: If-Modified-Since is ignored when If-None-Match is present.
+func notModified(request *http.Request, etag string, lastModified time.Time) bool {
+	if header := request.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+	if header := request.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		// HTTP dates have a one second resolution.
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// etagMatches reports whether a list of entity tags contains etag, using the
+// weak comparison required for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"microservice/src/cache"
+	"microservice/src/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct {
+	mongoPing  func(ctx context.Context) error
+	cacheStore *cache.BreakerStore
+}
+
+// NewHealthController reports on MongoDB through mongoPing and on Redis
+// through the circuit breaker guarding it, which also guards the sessions.
+// Either may be nil when the API runs without that service, sessions then
+// being kept in memory.
+func NewHealthController(mongoPing func(ctx context.Context) error, cacheStore *cache.BreakerStore) *HealthController {
+	return &HealthController{
+		mongoPing:  mongoPing,
+		cacheStore: cacheStore,
+	}
+}
+
+// Health godoc
+// @Summary Service health
+// @Tags health
+// @Description reports "degraded" while the cache is bypassed and sessions are unavailable, and responds 503 when MongoDB is unreachable
+// @ID health
+// @Produce  json
+// @Success 200 {object} models.Health
+// @Failure 503 {object} models.Health
+// @Router /health [get]
+func (controller *HealthController) Health(c *gin.Context) {
+	health := models.Health{Status: "ok", Components: map[string]string{
+		"mongodb":  "disabled",
+		"redis":    "disabled",
+		"sessions": "up",
+	}}
+	code := http.StatusOK
+
+	if controller.cacheStore != nil {
+		health.Components["redis"] = "up"
+		if !controller.cacheStore.Available() {
+			health.Components["redis"] = "down"
+			health.Components["sessions"] = "down"
+			health.Status = "degraded"
+		}
+	}
+
+	if controller.mongoPing != nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		health.Components["mongodb"] = "up"
+		if err := controller.mongoPing(ctx); err != nil {
+			health.Components["mongodb"] = "down"
+			health.Status = "down"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, health)
+}
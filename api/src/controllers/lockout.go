@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"math"
+	"microservice/src/lockout"
+	"microservice/src/repository"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// WithLockout throttles sign in after failed attempts, counted both per
+// username, against guessing one user's password, and per client address,
+// against trying a few passwords on many users.
+func (controller *AuthController) WithLockout(usernames, addresses lockout.Limiter) *AuthController {
+	controller.usernameAttempts = usernames
+	controller.addressAttempts = addresses
+	return controller
+}
+
+// allowSignIn answers the request itself, and returns false, while the
+// username or client address is blocked. Blocked attempts are not counted,
+// and the password is not checked.
+func (controller *AuthController) allowSignIn(c *gin.Context, username string) bool {
+	if controller.usernameAttempts == nil {
+		return true
+	}
+	ctx := c.Request.Context()
+	wait, err := controller.usernameAttempts.Blocked(ctx, username)
+	if err == nil && wait == 0 {
+		wait, err = controller.addressAttempts.Blocked(ctx, c.ClientIP())
+	}
+	if err != nil {
+		// Failing open would allow unlimited guesses while Redis is down.
+		log.Error("Failed to check sign in attempts: ", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Cannot sign in at the moment"})
+		return false
+	}
+	if wait > 0 {
+		authEvents.WithLabelValues("signin", "throttled").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign in attempts, try again later"})
+		return false
+	}
+	return true
+}
+
+// signInFailed counts a wrong password against the username and the
+// client address.
+func (controller *AuthController) signInFailed(c *gin.Context, username string) {
+	authEvents.WithLabelValues("signin", "invalid_credentials").Inc()
+	if controller.usernameAttempts == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	for _, attempt := range []struct {
+		scope   string
+		key     string
+		limiter lockout.Limiter
+	}{
+		{"username", username, controller.usernameAttempts},
+		{"address", c.ClientIP(), controller.addressAttempts},
+	} {
+		block, err := attempt.limiter.Fail(ctx, attempt.key)
+		if err != nil {
+			log.Error("Failed to record sign in attempt: ", err)
+			continue
+		}
+		if block.Lockout {
+			authEvents.WithLabelValues("lockout", attempt.scope).Inc()
+			log.Warn("Locked out sign in by ", attempt.scope, " ", attempt.key, " for ", block.RetryAfter)
+		}
+	}
+}
+
+// clearFailures forgets the failures of a username whose password was
+// right. Those of the address are kept: an attacker could otherwise reset
+// them by signing in to an account of their own between guesses.
+func (controller *AuthController) clearFailures(c *gin.Context, username string) {
+	if controller.usernameAttempts == nil {
+		return
+	}
+	if err := controller.usernameAttempts.Reset(c.Request.Context(), username); err != nil {
+		log.Error("Failed to reset sign in attempts: ", err)
+	}
+}
+
+// Unlock godoc
+// @Summary Unlock sign in for a user
+// @Tags auth
+// @Description forget the failed sign in attempts of a user, lifting a lockout. Failures counted against client addresses are kept, unless the address is given in ip.
+// @ID unlock-user
+// @Produce  json
+// @Param username path string true "Username"
+// @Param ip query string false "Client address to unlock as well"
+// @Success 200 {object} object
+// @Failure 400,403,404 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /users/{username}/lockout [delete]
+func (controller *AuthController) Unlock(c *gin.Context) {
+	if controller.usernameAttempts == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sign in lockout is not enabled"})
+		return
+	}
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
+	// Addresses are counted in the form gin.Context.ClientIP gives them.
+	address := c.Query("ip")
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+			return
+		}
+		address = ip.String()
+	}
+
+	ctx := c.Request.Context()
+	user, err := controller.users.FindByUsername(ctx, c.Param("username"))
+	if err == repository.ErrUserNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := controller.usernameAttempts.Reset(ctx, user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if address != "" {
+		if err := controller.addressAttempts.Reset(ctx, address); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	authEvents.WithLabelValues("unlock", "admin").Inc()
+	log.Info("Unlocked sign in for ", user.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "Sign in unlocked for " + user.Username})
+}
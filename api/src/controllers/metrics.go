@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// authEvents counts sign ins, refreshes and lockouts by outcome, to spot
+// password guessing and stolen refresh tokens.
+var authEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "auth_events_total",
+		Help: "Number of authentication events by outcome",
+	},
+	[]string{"event", "outcome"},
+)
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"microservice/src/cache"
+	"microservice/src/models"
+	"microservice/src/oidc"
+	"microservice/src/repository"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStatePrefix = "oidc:state:"
+	// oidcLoginTTL is how long a user has to sign in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// oidcLogin is kept between the redirect to the provider and the callback.
+type oidcLogin struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// WithOIDC lets users sign in with an OpenID Connect provider. Logins in
+// progress are kept in states, shared between replicas.
+func (controller *AuthController) WithOIDC(provider *oidc.Provider, states cache.Store) *AuthController {
+	controller.oidc = provider
+	controller.oidcStates = states
+	return controller
+}
+
+// OIDCLogin godoc
+// @Tags auth
+// @Summary Sign in with the identity provider
+// @Description redirect to the OpenID Connect provider, which sends the user back to /oidc/callback
+// @Success 302
+// @Failure 404 {object} httputil.HTTPError
+// @Failure 500,502 {object} httputil.HTTPError
+// @Router /oidc/login [get]
+func (controller *AuthController) OIDCLogin(c *gin.Context) {
+	if controller.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OpenID Connect is not configured"})
+		return
+	}
+
+	var state string
+	var login oidcLogin
+	var err error
+	for _, value := range []*string{&state, &login.Nonce, &login.Verifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	authURL, err := controller.oidc.AuthCodeURL(c.Request.Context(), state, login.Nonce, login.Verifier)
+	if err != nil {
+		log.Error("OpenID Connect provider unavailable: ", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	encoded, _ := json.Marshal(login)
+	if err := controller.oidcStates.Set(oidcStatePrefix+state, encoded, oidcLoginTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The cookie ties the callback to the browser that started the login.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginTTL/time.Second), "/oidc", "", isHTTPS(c), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Tags auth
+// @Summary Finish signing in with the identity provider
+// @Description exchange the authorization code, verify the ID token and return the API's own tokens; the first sign in creates or links a user
+// @Produce  json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State from /oidc/login"
+// @Success 200 {object} models.JWTOutput
+// @Failure 400,401,403,404,409 {object} httputil.HTTPError
+// @Failure 500,502 {object} httputil.HTTPError
+// @Router /oidc/callback [get]
+func (controller *AuthController) OIDCCallback(c *gin.Context) {
+	if controller.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OpenID Connect is not configured"})
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider refused sign in: " + reason})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	if state == "" || cookie != state {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign in was not started from this browser"})
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/oidc", "", isHTTPS(c), true)
+
+	// States are used once: of concurrent callbacks with the same state,
+	// only the one taking it goes on.
+	encoded, err := controller.oidcStates.Take(oidcStatePrefix + state)
+	if err == cache.ErrMiss {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign in has expired, start again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var login oidcLogin
+	if err := json.Unmarshal(encoded, &login); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rawIDToken, err := controller.oidc.Exchange(c.Request.Context(), c.Query("code"), login.Verifier)
+	if err != nil {
+		log.Error("OpenID Connect code exchange failed: ", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider did not accept the sign in"})
+		return
+	}
+	idToken, err := controller.oidc.Verify(c.Request.Context(), rawIDToken, login.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		authEvents.WithLabelValues("oidc", "invalid_id_token").Inc()
+		log.Warn(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+	if err != nil {
+		log.Error("OpenID Connect provider unavailable: ", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
+	user, err := controller.externalUser(c.Request.Context(), idToken)
+	if err == repository.ErrUserExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sessionID, refreshToken string
+	if controller.sessions != nil {
+		sessionID, refreshToken, err = controller.startSession(c, user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	authEvents.WithLabelValues("oidc", "success").Inc()
+	controller.writeTokens(c, user, sessionID, refreshToken)
+}
+
+// externalUser returns the user linked to the subject of an ID token. On
+// first sign in, the subject is linked to the user with the same verified
+// email, or else a user is created for it.
+func (controller *AuthController) externalUser(ctx context.Context, token *oidc.IDToken) (models.User, error) {
+	identity := models.ExternalIdentity{Issuer: token.Issuer, Subject: token.Subject}
+	user, err := controller.users.FindByIdentity(ctx, identity)
+	if err != repository.ErrUserNotFound {
+		return user, err
+	}
+
+	email := normalizeEmail(token.Email)
+	if email != "" && token.EmailVerified {
+		user, err := controller.users.FindByEmail(ctx, email)
+		if err == nil && user.EmailVerified {
+			if err := controller.users.LinkIdentity(ctx, user.Username, identity); err != nil {
+				return user, err
+			}
+			log.Info("Linked ", user.Username, " to OpenID Connect subject ", token.Subject)
+			return user, nil
+		}
+		if err == nil {
+			// Someone signed up with the address without verifying it.
+			email = ""
+		} else if err != repository.ErrUserNotFound {
+			return user, err
+		}
+	} else {
+		email = ""
+	}
+
+	base := externalUsername(token)
+	for attempt := 0; attempt < 5; attempt++ {
+		user = models.User{
+			Username:      base,
+			Role:          models.DefaultRole,
+			Email:         email,
+			EmailVerified: email != "",
+			CreatedAt:     time.Now(),
+			OIDC:          &identity,
+		}
+		if attempt > 0 {
+			suffix, err := randomToken()
+			if err != nil {
+				return user, err
+			}
+			user.Username = base + "-" + strings.ToLower(suffix[:4])
+		}
+		err = controller.users.Create(ctx, user)
+		if err != repository.ErrUserExists {
+			return user, err
+		}
+		// A concurrent first sign in may have created the user.
+		if linked, err := controller.users.FindByIdentity(ctx, identity); err == nil {
+			return linked, nil
+		}
+	}
+	return user, repository.ErrUserExists
+}
+
+var invalidUsernameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// externalUsername picks a username for a new user from the ID token.
+func externalUsername(token *oidc.IDToken) string {
+	localPart := strings.SplitN(token.Email, "@", 2)[0]
+	for _, candidate := range []string{token.PreferredUsername, localPart} {
+		candidate = invalidUsernameCharacters.ReplaceAllString(candidate, "-")
+		candidate = strings.TrimLeft(candidate, "_.-")
+		if len(candidate) > 32 {
+			candidate = candidate[:32]
+		}
+		if usernamePattern.MatchString(candidate) {
+			return candidate
+		}
+	}
+	return "user"
+}
+
+func isHTTPS(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
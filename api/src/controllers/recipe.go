@@ -1,32 +1,41 @@
 package controllers
 
 import (
-	"context"
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"microservice/src/cache"
+	"microservice/src/middlewares"
 	"microservice/src/models"
+	"microservice/src/patch"
+	"microservice/src/repository"
+	"microservice/src/search"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type RecipesController struct {
-	collection  *mongo.Collection
-	ctx         context.Context
-	redisClient *redis.Client
+	repository repository.RecipeRepository
+	revisions  repository.RevisionRepository
 }
 
-func NewRecipesController(ctx context.Context, collection *mongo.Collection, redisClient *redis.Client) *RecipesController {
+// NewRecipesController wires the handlers to a recipe store and the store
+// keeping its revision history. Caching is layered in the repository, see
+// repository.CachedRecipeRepository.
+func NewRecipesController(repository repository.RecipeRepository, revisions repository.RevisionRepository) *RecipesController {
 	return &RecipesController{
-		collection:  collection,
-		ctx:         ctx,
-		redisClient: redisClient,
+		repository: repository,
+		revisions:  revisions,
 	}
 }
 
@@ -34,47 +43,250 @@ func NewRecipesController(ctx context.Context, collection *mongo.Collection, red
 // @Summary Returns list of recipes
 // @Tags recipe
 // @Security ApiKeyAuth
-// @Description get recipes
+// @Description get recipes matching the filters, one page at a time
 // @ID get-recipes
 // @Accept  json
 // @Produce  json
-// @Success 200 {object} models.Recipe
-// @Header 200 {string} Token "qwerty"
+// @Param tags query string false "Comma separated tags"
+// @Param tags_match query string false "any (default) or all"
+// @Param ingredient query string false "Case insensitive ingredient substring"
+// @Param name_prefix query string false "Recipe name prefix"
+// @Param published_after query string false "RFC 3339 timestamp or YYYY-MM-DD, inclusive"
+// @Param published_before query string false "RFC 3339 timestamp or YYYY-MM-DD, exclusive"
+// @Param sort query string false "name, -name, publishedAt or -publishedAt (default)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.RecipeList
+// @Success 304 "Not Modified"
+// @Header 200 {string} Link "<...>; rel=\"next\", a trailer when the page is streamed"
+// @Header 200 {string} X-Cache "HIT, MISS, STALE or BYPASS"
+// @Header 200,304 {string} ETag "Strong entity tag of the page, a trailer when the page is streamed"
 // @Failure 400,404 {object} httputil.HTTPError
 // @Failure 500 {object} httputil.HTTPError
 // @Router /recipes [get]
 func (controller *RecipesController) ListRecipes(c *gin.Context) {
-	val, err := controller.redisClient.Get("recipes").Result()
-	if err == redis.Nil {
-		log.Info("Load data from MongoDB")
-		cur, err := controller.collection.Find(c, bson.M{})
+	listOptions, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	controller.writeRecipeList(c, listOptions, publicCacheControl)
+}
 
+func parseListOptions(c *gin.Context) (repository.ListOptions, error) {
+	listOptions := repository.ListOptions{Limit: repository.DefaultPageSize}
+	query, err := parseRecipeQuery(c)
+	if err != nil {
+		return listOptions, err
+	}
+	listOptions.Query = query
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			return listOptions, fmt.Errorf("limit must be between 1 and %d", repository.MaxPageSize)
+		}
+		listOptions.Limit = limit
+	}
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := repository.DecodeCursor(value)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return listOptions, err
+		}
+		if cursor.Sort != query.Sort() {
+			return listOptions, repository.ErrInvalidCursor
 		}
-		defer cur.Close(c)
+		listOptions.After = cursor
+	}
+	return listOptions, nil
+}
+
+// parseRecipeQuery reads the filter and sort parameters of GET /recipes and
+// returns them in normalized form.
+func parseRecipeQuery(c *gin.Context) (repository.RecipeQuery, error) {
+	var query repository.RecipeQuery
+	for _, value := range c.QueryArray("tags") {
+		query.Tags = append(query.Tags, strings.Split(value, ",")...)
+	}
+	switch c.DefaultQuery("tags_match", "any") {
+	case "any":
+	case "all":
+		query.MatchAllTags = true
+	default:
+		return query, errors.New("tags_match must be any or all")
+	}
+
+	query.Ingredient = c.Query("ingredient")
+	query.NamePrefix = c.Query("name_prefix")
+
+	var err error
+	if query.PublishedAfter, err = parseTimeParam(c, "published_after"); err != nil {
+		return query, err
+	}
+	if query.PublishedBefore, err = parseTimeParam(c, "published_before"); err != nil {
+		return query, err
+	}
 
-		recipes := make([]models.Recipe, 0)
-		for cur.Next(controller.ctx) {
-			var recipe models.Recipe
-			cur.Decode(&recipe)
-			recipes = append(recipes, recipe)
+	if value := c.Query("sort"); value != "" {
+		if query.SortBy, query.Ascending, err = repository.ParseSort(value); err != nil {
+			return query, err
 		}
+	}
+	return query.Normalize(), nil
+}
 
-		data, _ := json.Marshal(recipes)
-		controller.redisClient.Set("recipes", string(data), 0)
-		c.JSON(http.StatusOK, recipes)
-	} else if err != nil {
+// parseTimeParam accepts either an RFC 3339 timestamp or a plain date.
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+}
+
+// writeRecipeList sends a page of recipes, streamed from the store or
+// served whole from the cache, see recipeListWriter.
+func (controller *RecipesController) writeRecipeList(c *gin.Context, listOptions repository.ListOptions, cacheControl string) {
+	ctx, recorder := cache.WithStatusRecorder(c.Request.Context())
+	writer := &recipeListWriter{c: c, recorder: recorder, cacheControl: cacheControl}
+	err := controller.repository.WriteJSON(ctx, listOptions, writer)
+	if err == nil {
+		return
+	}
+	if writer.started {
+		// The status is sent: all that can be done is leave the body
+		// truncated, and without the ETag trailer.
+		log.Error("Failed to stream recipes: ", err)
+		return
+	}
+	writeCacheStatus(c, recorder)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// recipeListWriter sends a page of recipes with a Link to the next page,
+// if any. A page has no single modification time, since deletes change it
+// too, so it is only validated by its ETag.
+//
+// A page encoded beforehand, from the cache, gets its ETag and Link header
+// up front and may be answered with 304. A page streamed from the store is
+// sent as it is encoded; its ETag and Link are only known at the end, so
+// they follow the body as trailers, next_cursor in the body still leading
+// to the next page.
+type recipeListWriter struct {
+	c            *gin.Context
+	recorder     *cache.StatusRecorder
+	cacheControl string
+	hash         hash.Hash
+	started      bool
+}
+
+func (writer *recipeListWriter) WritePage(page repository.EncodedPage) error {
+	writer.started = true
+	writeCacheStatus(writer.c, writer.recorder)
+	if page.Next != nil {
+		writer.c.Header("Link", nextPageLink(writer.c, page.Next))
+	}
+	writeConditional(writer.c, page.Body, etagOf(page.Body), time.Time{}, writer.cacheControl)
+	return nil
+}
+
+func (writer *recipeListWriter) Write(p []byte) (int, error) {
+	if !writer.started {
+		writer.started = true
+		writeCacheStatus(writer.c, writer.recorder)
+		writer.c.Header("Cache-Control", writer.cacheControl)
+		writer.c.Header("Content-Type", "application/json; charset=utf-8")
+		writer.c.Header("Trailer", "ETag, Link")
+		writer.c.Status(http.StatusOK)
+		writer.hash = sha256.New()
+	}
+	writer.hash.Write(p)
+	return writer.c.Writer.Write(p)
+}
+
+func (writer *recipeListWriter) Finish(next *repository.Cursor) {
+	header := writer.c.Writer.Header()
+	header.Set("ETag", hashETag(writer.hash.Sum(nil)))
+	if next != nil {
+		header.Set("Link", nextPageLink(writer.c, next))
+	}
+}
+
+// nextPageLink is the Link header value pointing at the page after cursor.
+func nextPageLink(c *gin.Context, cursor *repository.Cursor) string {
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("cursor", cursor.Encode())
+	next.RawQuery = query.Encode()
+	return fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI())
+}
+
+// writeCacheStatus reports how the cache answered in X-Cache. Stale data,
+// served while the store is being refreshed or is down, also gets a Warning.
+func writeCacheStatus(c *gin.Context, recorder *cache.StatusRecorder) {
+	status := recorder.Status()
+	if status == "" {
+		return
+	}
+	c.Header("X-Cache", string(status))
+	if status == cache.StatusStale {
+		c.Header("Warning", `110 - "Response is Stale"`)
+	}
+}
+
+// SearchRecipes godoc
+// @Summary Search recipes
+// @Tags recipe
+// @Description full-text search across name, tags, ingredients and instructions, most relevant first
+// @ID search-recipes
+// @Accept  json
+// @Produce  json
+// @Param q query string true "Search terms"
+// @Param limit query int false "Maximum number of results (default 20, max 100)"
+// @Success 200 {object} models.RecipeSearchResults
+// @Failure 400 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Router /recipes/search [get]
+func (controller *RecipesController) SearchRecipes(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len(search.Tokenize(q)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one search term"})
+		return
+	}
+
+	limit := repository.DefaultPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", repository.MaxPageSize)})
+			return
+		}
+	}
+
+	results, err := controller.repository.Search(c.Request.Context(), q, limit)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
-	} else {
-		log.Info("Load data from Redis - cache")
-		recipes := make([]models.Recipe, 0)
-		json.Unmarshal([]byte(val), &recipes)
-		c.JSON(http.StatusOK, recipes)
 	}
 
+	response := models.RecipeSearchResults{Query: q, Results: make([]models.RecipeSearchHit, 0, len(results))}
+	for _, result := range results {
+		response.Results = append(response.Results, models.RecipeSearchHit{
+			Recipe:     result.Recipe,
+			Score:      result.Score,
+			Highlights: search.Highlight(result.Recipe, q),
+		})
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetRecipe godoc
@@ -85,7 +297,13 @@ func (controller *RecipesController) ListRecipes(c *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param id path string true "Recipe ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of a cached copy"
 // @Success 200 {object} models.Recipe
+// @Success 304 "Not Modified"
+// @Header 200 {string} X-Cache "HIT, MISS, STALE or BYPASS"
+// @Header 200,304 {string} ETag "Recipe version, for If-Match on writes"
+// @Header 200,304 {string} Last-Modified "Time of the last update"
 // @Header 200 {string} Token "qwerty"
 // @Failure 400,404 {object} httputil.HTTPError
 // @Failure 500 {object} httputil.HTTPError
@@ -95,19 +313,40 @@ func (controller *RecipesController) GetRecipe(c *gin.Context) {
 	id := c.Param("id")
 	log.Info("Get recipe: ", id)
 
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	cur := controller.collection.FindOne(controller.ctx, bson.M{
-		"_id": objectId,
-	})
-	var recipe models.Recipe
-	err := cur.Decode(&recipe)
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	ctx, recorder := cache.WithStatusRecorder(c.Request.Context())
+	recipe, err := controller.repository.Get(ctx, objectId)
+	writeCacheStatus(c, recorder)
+	if err == repository.ErrRecipeNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.Error("GetRecipe Error: ", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, recipe)
+	body, err := json.Marshal(recipe)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeConditional(c, body, versionETag(recipe.Version), lastModified(recipe), publicCacheControl)
+}
+
+// lastModified falls back to the publication time for recipes stored
+// before updates were timestamped.
+func lastModified(recipe models.Recipe) time.Time {
+	if recipe.UpdatedAt.IsZero() {
+		return recipe.PublishedAt
+	}
+	return recipe.UpdatedAt
 }
 
 // NewRecipe godoc
@@ -132,31 +371,35 @@ func (controller *RecipesController) NewRecipe(c *gin.Context) {
 	}
 
 	recipe.ID = primitive.NewObjectID()
+	recipe.Author = middlewares.Claims(c).Username
 	recipe.PublishedAt = time.Now()
-	_, err := controller.collection.InsertOne(controller.ctx, recipe)
-	if err != nil {
+	recipe.UpdatedAt = recipe.PublishedAt
+	recipe.Version = 1
+	recipe.DeletedAt = nil
+	recipe.DeletedBy = ""
+	if err := controller.repository.Create(c.Request.Context(), recipe); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting a new recipe"})
 		return
 	}
 
-	log.Println("Remove data from Redis")
-	controller.redisClient.Del("recipes")
-
+	controller.recordRevision(c, recipe)
 	c.JSON(http.StatusOK, recipe)
 }
 
 // UpdateRecipe godoc
 // @Tags recipe
 // @Summary Update a recipe
-// @Description update recipe
+// @Description update recipe, provided it is still at the version the client read
 // @ID update-recipe
 // @Accept  json
 // @Produce  json
 // @Param id path int true "Recipe ID"
+// @Param If-Match header string false "ETag of the version being replaced, or * for any, required unless the body has a version"
 // @Param message body models.Recipe true "Recipe Info"
 // @Success 200 {object} models.Recipe
-// @Header 200 {string} Token "qwerty"
-// @Failure 400,404 {object} httputil.HTTPError
+// @Header 200 {string} ETag "New recipe version"
+// @Header 412 {string} ETag "Current recipe version"
+// @Failure 400,403,404,412,428 {object} httputil.HTTPError
 // @Failure 500 {object} httputil.HTTPError
 // @Security ApiKeyAuth
 // @Router /recipes [put]
@@ -168,46 +411,347 @@ func (controller *RecipesController) UpdateRecipe(c *gin.Context) {
 		return
 	}
 
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := controller.collection.UpdateOne(controller.ctx, bson.M{
-		"_id": objectId,
-	}, bson.D{{"$set", bson.D{
-		{"name", recipe.Name},
-		{"instructions", recipe.Instructions},
-		{"ingredients", recipe.Ingredients},
-		{"tags", recipe.Tags},
-	}}})
+	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	version, ok := requireVersion(c, recipe.Version)
+	if !ok {
+		return
+	}
+	current, ok := controller.authorizeWrite(c, objectId)
+	if !ok {
+		return
+	}
+	if version == anyVersion {
+		version = current.Version
+	}
+
+	recipe.Version = version
+	recipe.UpdatedAt = time.Now()
+	updated, err := controller.repository.Update(c.Request.Context(), objectId, recipe)
+	if err != nil {
+		writeWriteError(c, err)
+		return
+	}
+
+	controller.recordRevision(c, updated)
+	c.Header("ETag", versionETag(updated.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been updated", "version": updated.Version})
+}
+
+// PatchRecipe godoc
+// @Tags recipe
+// @Summary Partially update a recipe
+// @Description apply a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to name, tags, ingredients and instructions
+// @ID patch-recipe
+// @Accept  application/merge-patch+json,application/json-patch+json
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Param If-Match header string false "ETag of the version being patched, or * for any"
+// @Param message body object true "Merge patch object or array of JSON Patch operations"
+// @Success 200 {object} models.Recipe
+// @Header 200 {string} ETag "New recipe version"
+// @Header 412 {string} ETag "Current recipe version"
+// @Failure 400,403,404,409,412,415,422 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id} [patch]
+func (controller *RecipesController) PatchRecipe(c *gin.Context) {
+	objectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	apply := patch.Merge
+	switch c.ContentType() {
+	case "application/merge-patch+json":
+	case "application/json-patch+json":
+		apply = patch.Apply
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/merge-patch+json or application/json-patch+json"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been updated"})
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, conditional, err := expectedVersion(c, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if version == anyVersion {
+		conditional = false
+	}
+
+	// Without If-Match, or with If-Match: *, the patch applies to whatever
+	// version is current, so a concurrent write just means reading the
+	// recipe again.
+	for attempt := 0; ; attempt++ {
+		current, ok := controller.authorizeWrite(c, objectId)
+		if !ok {
+			return
+		}
+		if conditional && current.Version != version {
+			writeWriteError(c, &repository.VersionConflictError{Current: current.Version})
+			return
+		}
+
+		recipe, err := patchRecipe(current, body, apply)
+		if err != nil {
+			writePatchError(c, err)
+			return
+		}
+
+		recipe.UpdatedAt = time.Now()
+		updated, err := controller.repository.Update(c.Request.Context(), objectId, recipe)
+		var conflict *repository.VersionConflictError
+		if errors.As(err, &conflict) && !conditional && attempt+1 < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			writeWriteError(c, err)
+			return
+		}
+
+		controller.recordRevision(c, updated)
+		c.Header("ETag", versionETag(updated.Version))
+		c.JSON(http.StatusOK, updated)
+		return
+	}
+}
+
+// maxPatchAttempts bounds the retries of an unconditional patch racing
+// other writers.
+const maxPatchAttempts = 3
+
+var (
+	// errReadOnlyField is returned when a patch touches a field managed by
+	// the API.
+	errReadOnlyField = errors.New("only name, tags, ingredients and instructions can be patched")
+	// errInvalidRecipe is returned when a patch yields a document that is
+	// not a valid recipe.
+	errInvalidRecipe = errors.New("patched recipe is invalid")
+)
+
+// patchRecipe applies a patch to the JSON form of a recipe and validates the
+// result.
+func patchRecipe(current models.Recipe, body []byte, apply func(document, patch []byte) ([]byte, error)) (models.Recipe, error) {
+	document, err := json.Marshal(current)
+	if err != nil {
+		return current, err
+	}
+	patched, err := apply(document, body)
+	if err != nil {
+		return current, err
+	}
+
+	var recipe models.Recipe
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&recipe); err != nil {
+		return current, fmt.Errorf("%w: %v", errInvalidRecipe, err)
+	}
+
+	if recipe.ID != current.ID || recipe.Author != current.Author || !recipe.PublishedAt.Equal(current.PublishedAt) ||
+		!recipe.UpdatedAt.Equal(current.UpdatedAt) || recipe.Version != current.Version {
+		return current, errReadOnlyField
+	}
+	if strings.TrimSpace(recipe.Name) == "" {
+		return current, fmt.Errorf("%w: name is required", errInvalidRecipe)
+	}
+	return recipe, nil
+}
+
+// writePatchError distinguishes malformed patches from well-formed ones
+// that cannot be applied to this recipe.
+func writePatchError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, patch.ErrInvalidPatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, patch.ErrTestFailed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errInvalidRecipe), errors.Is(err, errReadOnlyField):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
 
 // DeleteRecipe godoc
 // @Summary Delete a recipe
 // @Tags recipe
-// @Description delete recipe by ID
+// @Description move recipe to the trash, provided it is still at the version the client read
 // @ID get-recipe
 // @Accept  json
 // @Produce  json
 // @Param id path int true "Recipe ID"
+// @Param If-Match header string true "ETag of the version being deleted, or * for any"
 // @Success 200 {object} models.Recipe
-// @Header 200 {string} Token "qwerty"
-// @Failure 400,404 {object} httputil.HTTPError
+// @Header 412 {string} ETag "Current recipe version"
+// @Failure 400,403,404,412,428 {object} httputil.HTTPError
 // @Failure 500 {object} httputil.HTTPError
 // @Security ApiKeyAuth
 // @Router /recipes/{id} [delete]
 func (controller *RecipesController) DeleteRecipe(c *gin.Context) {
 	id := c.Param("id")
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	_, err := controller.collection.DeleteOne(controller.ctx, bson.M{
-		"_id": objectId,
-	})
+	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been deleted"})
+
+	version, ok := requireVersion(c, 0)
+	if !ok {
+		return
+	}
+	current, ok := controller.authorizeWrite(c, objectId)
+	if !ok {
+		return
+	}
+	if version == anyVersion {
+		version = current.Version
+	}
+
+	deleted, err := controller.repository.Delete(c.Request.Context(), objectId, version, middlewares.Claims(c).Username)
+	if err != nil {
+		writeWriteError(c, err)
+		return
+	}
+	controller.recordRevision(c, deleted)
+	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been moved to the trash"})
+}
+
+// ListTrash godoc
+// @Summary Returns list of deleted recipes
+// @Tags recipe
+// @Description get recipes in the trash, one page at a time, with the same filters as the recipe list
+// @ID get-trash
+// @Produce  json
+// @Param sort query string false "name, -name, publishedAt or -publishedAt (default)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Success 200 {object} models.RecipeList
+// @Header 200 {string} Link "<...>; rel=\"next\", a trailer when the page is streamed"
+// @Failure 400 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /trash [get]
+func (controller *RecipesController) ListTrash(c *gin.Context) {
+	listOptions, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	listOptions.Query.Deleted = true
+
+	controller.writeRecipeList(c, listOptions, privateCacheControl)
+}
+
+// RestoreRecipe godoc
+// @Summary Restore a deleted recipe
+// @Tags recipe
+// @Description take a recipe out of the trash
+// @ID restore-recipe
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Success 200 {object} models.Recipe
+// @Failure 400,403,404 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id}/restore [post]
+func (controller *RecipesController) RestoreRecipe(c *gin.Context) {
+	objectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return
+	}
+
+	deleted, err := controller.repository.GetDeleted(c.Request.Context(), objectId)
+	if err != nil {
+		writeWriteError(c, err)
+		return
+	}
+	if !canModify(middlewares.Claims(c), deleted) {
+		writeForbidden(c)
+		return
+	}
+
+	restored, err := controller.repository.Restore(c.Request.Context(), objectId)
+	if err != nil {
+		writeWriteError(c, err)
+		return
+	}
+
+	controller.recordRevision(c, restored)
+	c.Header("ETag", versionETag(restored.Version))
+	c.JSON(http.StatusOK, restored)
+}
+
+// authorizeWrite loads a recipe and checks that the signed-in user may
+// change it, answering the request itself when either fails. Authors never
+// change, so the check holds for the conditional write that follows.
+func (controller *RecipesController) authorizeWrite(c *gin.Context, id primitive.ObjectID) (models.Recipe, bool) {
+	current, err := controller.repository.Get(c.Request.Context(), id)
+	if err != nil {
+		writeWriteError(c, err)
+		return current, false
+	}
+	if !canModify(middlewares.Claims(c), current) {
+		writeForbidden(c)
+		return current, false
+	}
+	return current, true
+}
+
+// canModify lets the author of a recipe, or a role allowed to moderate,
+// change it. Recipes created before authors were recorded can only be
+// changed by moderators.
+func canModify(claims *models.Claims, recipe models.Recipe) bool {
+	if middlewares.HasPermission(claims, middlewares.PermissionRecipesModerate) {
+		return true
+	}
+	return claims != nil && recipe.Author != "" && recipe.Author == claims.Username
+}
+
+func writeForbidden(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "Only the author of a recipe, an editor or an admin can change it"})
+}
+
+// requireVersion reads the version a write is conditioned on and answers
+// the request itself when it is missing or malformed.
+func requireVersion(c *gin.Context, bodyVersion int64) (int64, bool) {
+	version, ok, err := expectedVersion(c, bodyVersion)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header or version is required"})
+		return 0, false
+	}
+	return version, true
+}
+
+// writeWriteError maps repository errors from a write to a response. On a
+// version conflict the current version is returned so the client can
+// reload and retry.
+func writeWriteError(c *gin.Context, err error) {
+	var conflict *repository.VersionConflictError
+	switch {
+	case err == repository.ErrRecipeNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.As(err, &conflict):
+		c.Header("ETag", versionETag(conflict.Current))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "version": conflict.Current})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"microservice/src/middlewares"
+	"microservice/src/models"
+	"microservice/src/repository"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordRevision stores the recipe as left by the current request. The
+// write itself has already succeeded, so a failure is logged rather than
+// reported to the client.
+func (controller *RecipesController) recordRevision(c *gin.Context, recipe models.Recipe) {
+	err := controller.revisions.Add(c.Request.Context(), models.Revision{
+		RecipeID:  recipe.ID,
+		Number:    recipe.Version,
+		Author:    middlewares.Claims(c).Username,
+		CreatedAt: time.Now(),
+		Recipe:    &recipe,
+	})
+	if err != nil {
+		log.Error("Failed to record recipe revision: ", err)
+	}
+}
+
+// ListRevisions godoc
+// @Summary List the revisions of a recipe
+// @Tags revision
+// @Description get who changed a recipe and when, newest first
+// @ID get-revisions
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Success 200 {object} models.RevisionList
+// @Failure 400,404 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id}/revisions [get]
+func (controller *RecipesController) ListRevisions(c *gin.Context) {
+	objectId, ok := controller.visibleRecipe(c)
+	if !ok {
+		return
+	}
+
+	revisions, err := controller.revisions.List(c.Request.Context(), objectId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(revisions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrRevisionNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.RevisionList{Revisions: revisions})
+}
+
+// GetRevision godoc
+// @Summary Get a recipe revision
+// @Tags revision
+// @Description get the recipe as it was at a revision
+// @ID get-revision
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Param rev path int true "Revision number"
+// @Success 200 {object} models.Revision
+// @Failure 400,404 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id}/revisions/{rev} [get]
+func (controller *RecipesController) GetRevision(c *gin.Context) {
+	if _, ok := controller.visibleRecipe(c); !ok {
+		return
+	}
+	revision, ok := controller.findRevision(c, c.Param("rev"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffRevisions godoc
+// @Summary Compare two recipe revisions
+// @Tags revision
+// @Description list the fields changed between two revisions; the first revision is compared with an empty recipe
+// @ID diff-revisions
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Param rev path int true "Revision number"
+// @Param against query int false "Revision to compare with (default the previous one, if any)"
+// @Success 200 {object} models.RevisionDiff
+// @Failure 400,404 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id}/revisions/{rev}/diff [get]
+func (controller *RecipesController) DiffRevisions(c *gin.Context) {
+	if _, ok := controller.visibleRecipe(c); !ok {
+		return
+	}
+	to, ok := controller.findRevision(c, c.Param("rev"))
+	if !ok {
+		return
+	}
+	from := models.Revision{Recipe: &models.Recipe{}}
+	against, compare := c.GetQuery("against")
+	if !compare && to.Number > 1 {
+		against, compare = strconv.FormatInt(to.Number-1, 10), true
+	}
+	if compare {
+		if from, ok = controller.findRevision(c, against); !ok {
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.RevisionDiff{
+		From:    from.Number,
+		To:      to.Number,
+		Changes: diffRecipes(*from.Recipe, *to.Recipe),
+	})
+}
+
+// RevertRecipe godoc
+// @Summary Revert a recipe to a revision
+// @Tags revision
+// @Description restore name, tags, ingredients and instructions from a revision, recorded as a new revision
+// @ID revert-recipe
+// @Produce  json
+// @Param id path string true "Recipe ID"
+// @Param rev path int true "Revision number"
+// @Param If-Match header string false "ETag of the version being replaced, or * for any, required unless the body has a version"
+// @Param message body models.RevertRequest false "Version being replaced"
+// @Success 200 {object} models.Recipe
+// @Header 200 {string} ETag "New recipe version"
+// @Header 412 {string} ETag "Current recipe version"
+// @Failure 400,403,404,412,428 {object} httputil.HTTPError
+// @Failure 500 {object} httputil.HTTPError
+// @Security ApiKeyAuth
+// @Router /recipes/{id}/revisions/{rev}/revert [post]
+func (controller *RecipesController) RevertRecipe(c *gin.Context) {
+	revision, ok := controller.findRevision(c, c.Param("rev"))
+	if !ok {
+		return
+	}
+
+	// The body is optional, as If-Match may name the version instead.
+	var request models.RevertRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	version, ok := requireVersion(c, request.Version)
+	if !ok {
+		return
+	}
+	current, ok := controller.authorizeWrite(c, revision.RecipeID)
+	if !ok {
+		return
+	}
+	if version == anyVersion {
+		version = current.Version
+	}
+
+	recipe := *revision.Recipe
+	recipe.Version = version
+	recipe.UpdatedAt = time.Now()
+	updated, err := controller.repository.Update(c.Request.Context(), revision.RecipeID, recipe)
+	if err != nil {
+		writeWriteError(c, err)
+		return
+	}
+
+	controller.recordRevision(c, updated)
+	c.Header("ETag", versionETag(updated.Version))
+	c.JSON(http.StatusOK, updated)
+}
+
+// visibleRecipe checks that the caller may see the recipe in the path:
+// while it is in the trash, only those who may browse the trash can, and
+// once purged, nobody can. It answers the request itself when they may not.
+func (controller *RecipesController) visibleRecipe(c *gin.Context) (primitive.ObjectID, bool) {
+	objectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return objectId, false
+	}
+
+	_, err = controller.repository.Get(c.Request.Context(), objectId)
+	if err == repository.ErrRecipeNotFound && middlewares.HasPermission(middlewares.Claims(c), middlewares.PermissionRecipesModerate) {
+		_, err = controller.repository.GetDeleted(c.Request.Context(), objectId)
+	}
+	if err == repository.ErrRecipeNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return objectId, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return objectId, false
+	}
+	return objectId, true
+}
+
+// findRevision loads a revision of the recipe in the path, answering the
+// request itself when it cannot.
+func (controller *RecipesController) findRevision(c *gin.Context, number string) (models.Revision, bool) {
+	objectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe ID"})
+		return models.Revision{}, false
+	}
+	revisionNumber, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || revisionNumber < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return models.Revision{}, false
+	}
+
+	revision, err := controller.revisions.Get(c.Request.Context(), objectId, revisionNumber)
+	if err == repository.ErrRevisionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return revision, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return revision, false
+	}
+	return revision, true
+}
+
+// diffRecipes compares the fields a client can edit, plus whether the
+// recipe was in the trash.
+func diffRecipes(from, to models.Recipe) []models.FieldChange {
+	changes := make([]models.FieldChange, 0)
+	if from.Name != to.Name {
+		changes = append(changes, models.FieldChange{Field: "name", From: from.Name, To: to.Name})
+	}
+	for _, field := range []struct {
+		name     string
+		from, to []string
+	}{
+		{"tags", from.Tags, to.Tags},
+		{"ingredients", from.Ingredients, to.Ingredients},
+		{"instructions", from.Instructions, to.Instructions},
+	} {
+		if reflect.DeepEqual(emptyIfNil(field.from), emptyIfNil(field.to)) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{
+			Field:   field.name,
+			From:    field.from,
+			To:      field.to,
+			Added:   subtract(field.to, field.from),
+			Removed: subtract(field.from, field.to),
+		})
+	}
+	if (from.DeletedAt == nil) != (to.DeletedAt == nil) {
+		changes = append(changes, models.FieldChange{Field: "deletedAt", From: from.DeletedAt, To: to.DeletedAt})
+	}
+	return changes
+}
+
+// subtract returns the items of a missing from b, counting duplicates.
+func subtract(a, b []string) []string {
+	remaining := make(map[string]int, len(b))
+	for _, item := range b {
+		remaining[item]++
+	}
+	var result []string
+	for _, item := range a {
+		if remaining[item] > 0 {
+			remaining[item]--
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func emptyIfNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
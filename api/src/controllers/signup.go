@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"microservice/src/mail"
+	"microservice/src/models"
+	"microservice/src/password"
+	"microservice/src/repository"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 128
+	verificationTTL   = 24 * time.Hour
+	// resendCooldown is how long a verification link is kept before asking
+	// again sends a new one, so the endpoint cannot flood an inbox.
+	resendCooldown = 5 * time.Minute
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{2,31}$`)
+
+// SignUp godoc
+// @Tags auth
+// @Summary Register a user
+// @Description create a contributor account and email a link to verify its address; sign in is refused until it is followed
+// @Accept  json
+// @Produce  json
+// @Param message body models.SignUpRequest true "Username, email and password"
+// @Success 201 {object} object
+// @Failure 400,409 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Router /signup [post]
+func (controller *AuthController) SignUp(c *gin.Context) {
+	var request models.SignUpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
+
+	email, err := validateSignUp(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := password.Hash(request.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token, verification, err := newVerification()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = controller.users.Create(c.Request.Context(), models.User{
+		Username:     request.Username,
+		Password:     hash,
+		Role:         models.DefaultRole,
+		Email:        email,
+		Verification: &verification,
+		CreatedAt:    time.Now(),
+	})
+	if err == repository.ErrUserExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The account exists either way; a lost email can be sent again.
+	controller.sendVerification(c, email, token)
+	c.JSON(http.StatusCreated, gin.H{"message": "Check your email to verify your account"})
+}
+
+// ResendVerification godoc
+// @Tags auth
+// @Summary Send a new verification email
+// @Description replace the pending verification link of an unverified account, unless it was sent in the last 5 minutes; the response does not reveal whether the email is registered
+// @Accept  json
+// @Produce  json
+// @Param message body models.ResendVerificationRequest true "Email"
+// @Success 202 {object} object
+// @Failure 400 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Router /verify-email/resend [post]
+func (controller *AuthController) ResendVerification(c *gin.Context) {
+	var request models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
+
+	email := normalizeEmail(request.Email)
+	token, verification, err := newVerification()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Links all last verificationTTL, so one sent within the cooldown
+	// expires less than resendCooldown before the new one would, and is kept.
+	replaceBefore := verification.ExpiresAt.Add(-resendCooldown)
+	err = controller.users.SetVerification(c.Request.Context(), email, verification, replaceBefore)
+	if err != nil && err != repository.ErrUserNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		controller.sendVerification(c, email, token)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email belongs to an unverified account, a new link has been sent unless one was sent in the last few minutes"})
+}
+
+// VerifyEmail godoc
+// @Tags auth
+// @Summary Verify an email address
+// @Description follow the link mailed on sign up
+// @Produce  json
+// @Param token query string true "Verification token"
+// @Success 200 {object} object
+// @Failure 400 {object} httputil.HTTPError
+// @Failure 500,503 {object} httputil.HTTPError
+// @Router /verify-email [get]
+func (controller *AuthController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	if controller.users == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Users store is not configured"})
+		return
+	}
+
+	user, err := controller.users.VerifyEmail(c.Request.Context(), hashToken(token), time.Now())
+	if err == repository.ErrVerificationNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Info("Verified email of ", user.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified, you can now sign in"})
+}
+
+// sendVerification mails the verification link. Failures are logged: the
+// user can ask for another link.
+func (controller *AuthController) sendVerification(c *gin.Context, email, token string) {
+	if controller.mailer == nil {
+		log.Warn("No mail sender configured, cannot send verification to ", email)
+		return
+	}
+	link := controller.verifyURL + "?" + url.Values{"token": {token}}.Encode()
+	err := controller.mailer.Send(c.Request.Context(), mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome to the recipes API!\r\n\r\n"+
+			"Open the link below within %d hours to verify your email address:\r\n\r\n%s\r\n", int(verificationTTL/time.Hour), link),
+	})
+	if err != nil {
+		log.Error("Failed to send verification email: ", err)
+	}
+}
+
+// validateSignUp checks a sign up request and returns the normalized email.
+func validateSignUp(request models.SignUpRequest) (string, error) {
+	if !usernamePattern.MatchString(request.Username) {
+		return "", errors.New("username must be 3 to 32 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+	email := normalizeEmail(request.Email)
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "", errors.New("email is not a valid address")
+	}
+	length := len([]rune(request.Password))
+	if length < minPasswordLength || length > maxPasswordLength {
+		return "", fmt.Errorf("password must be %d to %d characters long", minPasswordLength, maxPasswordLength)
+	}
+	if strings.EqualFold(request.Password, request.Username) || strings.EqualFold(request.Password, email) {
+		return "", errors.New("password must not be the username or email")
+	}
+	return email, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// newVerification returns a random token and the verification to store
+// for it.
+func newVerification() (string, models.EmailVerification, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", models.EmailVerification{}, err
+	}
+	return token, models.EmailVerification{
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(verificationTTL),
+	}, nil
+}
+
+// randomToken returns 256 random bits, URL safe.
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken is how verification and refresh tokens are stored. They are
+// random, so a fast unsalted hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
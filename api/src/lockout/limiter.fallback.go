@@ -0,0 +1,61 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// FallbackLimiter runs every call to limiter through guard, typically the
+// circuit breaker of the Redis server holding the counters, and turns to
+// fallback when it fails. During an outage sign ins are then throttled by
+// each replica on its own rather than refused or slowed down by timeouts.
+type FallbackLimiter struct {
+	limiter  Limiter
+	fallback Limiter
+	guard    func(call func() error) error
+}
+
+func NewFallbackLimiter(limiter, fallback Limiter, guard func(call func() error) error) *FallbackLimiter {
+	return &FallbackLimiter{
+		limiter:  limiter,
+		fallback: fallback,
+		guard:    guard,
+	}
+}
+
+func (limiter *FallbackLimiter) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	var wait time.Duration
+	err := limiter.guard(func() error {
+		var err error
+		wait, err = limiter.limiter.Blocked(ctx, key)
+		return err
+	})
+	if err != nil {
+		return limiter.fallback.Blocked(ctx, key)
+	}
+	return wait, nil
+}
+
+func (limiter *FallbackLimiter) Fail(ctx context.Context, key string) (Block, error) {
+	var block Block
+	err := limiter.guard(func() error {
+		var err error
+		block, err = limiter.limiter.Fail(ctx, key)
+		return err
+	})
+	if err != nil {
+		return limiter.fallback.Fail(ctx, key)
+	}
+	return block, nil
+}
+
+// Reset clears key in both limiters, so a lockout recorded locally during
+// an outage is lifted as well.
+func (limiter *FallbackLimiter) Reset(ctx context.Context, key string) error {
+	if err := limiter.fallback.Reset(ctx, key); err != nil {
+		return err
+	}
+	return limiter.guard(func() error {
+		return limiter.limiter.Reset(ctx, key)
+	})
+}
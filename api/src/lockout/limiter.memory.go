@@ -0,0 +1,75 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	failures     int64
+	blockedUntil time.Time
+	expiresAt    time.Time
+}
+
+// MemoryLimiter counts failures in process memory, for tests and for
+// running the API without Redis. Expired entries are dropped when next
+// read.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	policy  Policy
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+func NewMemoryLimiter(policy Policy) *MemoryLimiter {
+	return &MemoryLimiter{
+		policy:  policy,
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (limiter *MemoryLimiter) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	entry := limiter.entry(key)
+	if wait := entry.blockedUntil.Sub(limiter.now()); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+func (limiter *MemoryLimiter) Fail(ctx context.Context, key string) (Block, error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+	entry := limiter.entry(key)
+	entry.failures++
+	block := limiter.policy.block(entry.failures)
+	if block.RetryAfter > 0 {
+		entry.blockedUntil = now.Add(block.RetryAfter)
+	}
+	entry.expiresAt = now.Add(limiter.policy.retention(block.RetryAfter))
+	limiter.entries[key] = entry
+	return block, nil
+}
+
+func (limiter *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	delete(limiter.entries, key)
+	return nil
+}
+
+func (limiter *MemoryLimiter) entry(key string) memoryEntry {
+	entry, ok := limiter.entries[key]
+	if ok && !limiter.now().Before(entry.expiresAt) {
+		delete(limiter.entries, key)
+		return memoryEntry{}
+	}
+	return entry
+}
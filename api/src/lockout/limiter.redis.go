@@ -0,0 +1,63 @@
+package lockout
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisLimiter shares failure counts between replicas. The failures of a
+// key are counted in <prefix><key>:failures, and a block is the key
+// <prefix><key>:blocked expiring when it ends.
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+	policy Policy
+}
+
+func NewRedisLimiter(client *redis.Client, prefix string, policy Policy) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+		policy: policy,
+	}
+}
+
+func (limiter *RedisLimiter) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := limiter.client.WithContext(ctx).PTTL(limiter.prefix + key + ":blocked").Result()
+	if err != nil || ttl < 0 {
+		// Missing keys have a negative TTL.
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (limiter *RedisLimiter) Fail(ctx context.Context, key string) (Block, error) {
+	client := limiter.client.WithContext(ctx)
+	failuresKey := limiter.prefix + key + ":failures"
+	var failures *redis.IntCmd
+	_, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(failuresKey)
+		pipe.PExpire(failuresKey, limiter.policy.Window)
+		return nil
+	})
+	if err != nil {
+		return Block{}, err
+	}
+
+	block := limiter.policy.block(failures.Val())
+	if block.RetryAfter == 0 {
+		return block, nil
+	}
+	_, err = client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(limiter.prefix+key+":blocked", "1", block.RetryAfter)
+		pipe.PExpire(failuresKey, limiter.policy.retention(block.RetryAfter))
+		return nil
+	})
+	return block, err
+}
+
+func (limiter *RedisLimiter) Reset(ctx context.Context, key string) error {
+	return limiter.client.WithContext(ctx).Del(limiter.prefix+key+":failures", limiter.prefix+key+":blocked").Err()
+}
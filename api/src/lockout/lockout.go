@@ -0,0 +1,73 @@
+// Package lockout slows down password guessing. Failed attempts are
+// counted per key, such as a username or a client address. Past a few free
+// failures each one blocks the key for twice as long as the previous one,
+// and enough of them lock it out for a while.
+//
+// Attempts are checked before and recorded after verifying the password,
+// so concurrent attempts can slip past a block that is about to start.
+// Each of them still counts towards the lockout.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Policy decides how long failures block a key.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed without delay.
+	FreeAttempts int64
+	// BaseDelay blocks the key after the first failure past FreeAttempts.
+	// The delay doubles with each further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAttempts failures lock the key out for LockoutDuration.
+	LockoutAttempts int64
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Block is how a failed attempt blocks its key.
+type Block struct {
+	RetryAfter time.Duration
+	// Lockout is set when this failure locked the key out.
+	Lockout bool
+}
+
+// block returns how long the failures-th failure blocks its key.
+func (policy Policy) block(failures int64) Block {
+	if failures >= policy.LockoutAttempts {
+		return Block{RetryAfter: policy.LockoutDuration, Lockout: failures == policy.LockoutAttempts}
+	}
+	if failures <= policy.FreeAttempts {
+		return Block{}
+	}
+	delay := policy.BaseDelay
+	for i := policy.FreeAttempts + 1; i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return Block{RetryAfter: delay}
+}
+
+// retention is how long the failures of a key blocked for delay are kept.
+func (policy Policy) retention(delay time.Duration) time.Duration {
+	if delay > policy.Window {
+		return delay
+	}
+	return policy.Window
+}
+
+// Limiter counts failed attempts per key.
+type Limiter interface {
+	// Blocked returns how long key must wait before its next attempt, or 0
+	// when it may try now.
+	Blocked(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failed attempt by key and returns the block it earned.
+	Fail(ctx context.Context, key string) (Block, error)
+	// Reset forgets the failures of key, lifting any block.
+	Reset(ctx context.Context, key string) error
+}
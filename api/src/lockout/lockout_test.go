@@ -0,0 +1,136 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Second,
+	LockoutAttempts: 8,
+	LockoutDuration: time.Hour,
+	Window:          15 * time.Minute,
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	var delays []time.Duration
+	for failures := int64(1); failures <= 9; failures++ {
+		delays = append(delays, testPolicy.block(failures).RetryAfter)
+	}
+	assert.Equal(t, []time.Duration{
+		0, 0,
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+		time.Hour, time.Hour,
+	}, delays)
+
+	assert.False(t, testPolicy.block(7).Lockout)
+	assert.True(t, testPolicy.block(8).Lockout)
+	// Only the failure that locks the key out reports it.
+	assert.False(t, testPolicy.block(9).Lockout)
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter(testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		block, err := limiter.Fail(ctx, "packt")
+		assert.Nil(t, err)
+		assert.Zero(t, block.RetryAfter)
+	}
+	wait, _ := limiter.Blocked(ctx, "packt")
+	assert.Zero(t, wait)
+
+	block, _ := limiter.Fail(ctx, "packt")
+	assert.Equal(t, time.Second, block.RetryAfter)
+	now = now.Add(500 * time.Millisecond)
+	wait, _ = limiter.Blocked(ctx, "packt")
+	assert.Equal(t, 500*time.Millisecond, wait)
+	// Other keys are unaffected.
+	wait, _ = limiter.Blocked(ctx, "mlabouardy")
+	assert.Zero(t, wait)
+
+	now = now.Add(time.Second)
+	wait, _ = limiter.Blocked(ctx, "packt")
+	assert.Zero(t, wait)
+	block, _ = limiter.Fail(ctx, "packt")
+	assert.Equal(t, 2*time.Second, block.RetryAfter)
+
+	assert.Nil(t, limiter.Reset(ctx, "packt"))
+	wait, _ = limiter.Blocked(ctx, "packt")
+	assert.Zero(t, wait)
+	block, _ = limiter.Fail(ctx, "packt")
+	assert.Zero(t, block.RetryAfter)
+}
+
+func TestMemoryLimiterForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter(testPolicy)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 8; i++ {
+		limiter.Fail(ctx, "packt")
+	}
+	wait, _ := limiter.Blocked(ctx, "packt")
+	assert.Equal(t, time.Hour, wait)
+
+	// The lockout outlasts the window, and the count with it.
+	now = now.Add(30 * time.Minute)
+	wait, _ = limiter.Blocked(ctx, "packt")
+	assert.Equal(t, 30*time.Minute, wait)
+
+	now = now.Add(time.Hour)
+	wait, _ = limiter.Blocked(ctx, "packt")
+	assert.Zero(t, wait)
+	block, _ := limiter.Fail(ctx, "packt")
+	assert.Zero(t, block.RetryAfter)
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	ctx := context.Background()
+	errOpen := errors.New("circuit open")
+	open := false
+	guard := func(call func() error) error {
+		if open {
+			return errOpen
+		}
+		return call()
+	}
+	primary, fallback := NewMemoryLimiter(testPolicy), NewMemoryLimiter(testPolicy)
+	limiter := NewFallbackLimiter(primary, fallback, guard)
+
+	for i := 0; i < 3; i++ {
+		limiter.Fail(ctx, "packt")
+	}
+	wait, err := limiter.Blocked(ctx, "packt")
+	assert.Nil(t, err)
+	assert.True(t, wait > 0)
+
+	// While the primary is unavailable, failures are counted locally.
+	open = true
+	wait, err = limiter.Blocked(ctx, "packt")
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+	for i := 0; i < 3; i++ {
+		_, err = limiter.Fail(ctx, "mlabouardy")
+		assert.Nil(t, err)
+	}
+	wait, _ = limiter.Blocked(ctx, "mlabouardy")
+	assert.True(t, wait > 0)
+	wait, _ = primary.Blocked(ctx, "mlabouardy")
+	assert.Zero(t, wait)
+
+	open = false
+	assert.Nil(t, limiter.Reset(ctx, "packt"))
+	wait, _ = primary.Blocked(ctx, "packt")
+	assert.Zero(t, wait)
+}
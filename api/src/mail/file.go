@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSender writes each message to its own .eml file in a directory
+// instead of sending it, so links can be followed by hand or by tests.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func NewFileSender(dir, from string) *FileSender {
+	return &FileSender{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (sender *FileSender) Send(ctx context.Context, message Message) error {
+	if err := os.MkdirAll(sender.dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	recipient := strings.NewReplacer("/", "_", "\\", "_").Replace(message.To)
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), recipient)
+	return ioutil.WriteFile(filepath.Join(sender.dir, name), message.format(sender.from, now), 0o644)
+}
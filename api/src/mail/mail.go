@@ -0,0 +1,38 @@
+// Package mail sends the emails the API needs, such as address
+// verification links, through a pluggable Sender.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages. FileSender stands in for a mail server during
+// development and tests, SMTPSender relays through one.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// format renders message as an RFC 5322 email.
+func (message Message) format(from string, date time.Time) []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", from)
+	fmt.Fprintf(&buffer, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(message.Body)
+	return buffer.Bytes()
+}
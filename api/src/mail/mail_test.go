@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var verification = Message{
+	To:      "cook@example.com",
+	Subject: "Verify your email address",
+	Body:    "https://recipes.example.com/verify-email?token=abc\r\n",
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := NewFileSender(dir, "recipes@example.com")
+
+	assert.Nil(t, sender.Send(context.Background(), verification))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0].Name(), "-cook@example.com.eml"))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "From: recipes@example.com\r\n")
+	assert.Contains(t, string(content), "To: cook@example.com\r\n")
+	assert.Contains(t, string(content), "Subject: Verify your email address\r\n")
+	assert.True(t, strings.HasSuffix(string(content), "\r\n\r\n"+verification.Body))
+}
+
+// TestSMTPSender talks to a minimal SMTP server that records the message.
+func TestSMTPSender(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				received <- data.String()
+				reply("250 OK")
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	sender := NewSMTPSender(listener.Addr().String(), "recipes@example.com", "", "")
+	assert.Nil(t, sender.Send(context.Background(), verification))
+
+	data := <-received
+	assert.Contains(t, data, "To: cook@example.com\r\n")
+	assert.Contains(t, data, verification.Body)
+}
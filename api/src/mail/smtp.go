@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPSender relays messages through an SMTP server, such as MailHog
+// locally or the provider's relay in production.
+type SMTPSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPSender relays through addr, given as host:port. Authentication is
+// skipped when username is empty.
+func NewSMTPSender(addr, from, username, password string) *SMTPSender {
+	sender := &SMTPSender{
+		addr: addr,
+		from: from,
+	}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sender
+}
+
+func (sender *SMTPSender) Send(ctx context.Context, message Message) error {
+	return smtp.SendMail(sender.addr, sender.auth, sender.from, []string{message.To}, message.format(sender.from, time.Now()))
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"microservice/src/models"
+	"microservice/src/repository"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader carries API keys.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyTouchInterval limits how often the last use of a key is written.
+const apiKeyTouchInterval = time.Minute
+
+// NewAPIKey generates an API key of the form rk_<prefix>_<secret>. The
+// prefix finds the key, the hash of the whole key verifies it.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	raw := make([]byte, 6+32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(raw[:6])
+	key = "rk_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(raw[6:])
+	return key, prefix, hashAPIKey(key), nil
+}
+
+func parseAPIKey(key string) (prefix string, ok bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != "rk" || len(parts[1]) != 12 || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyAuthenticator accepts API keys in the X-API-Key header, and
+// records when each was last used.
+func APIKeyAuthenticator(keys repository.APIKeyRepository) Authenticator {
+	return func(c *gin.Context) (*models.Claims, error) {
+		value := c.GetHeader(APIKeyHeader)
+		if value == "" {
+			return nil, nil
+		}
+		prefix, ok := parseAPIKey(value)
+		if !ok {
+			return nil, unauthorized("Invalid API key")
+		}
+
+		key, err := keys.FindByPrefix(c.Request.Context(), prefix)
+		if err == repository.ErrAPIKeyNotFound {
+			return nil, unauthorized("Invalid API key")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(hashAPIKey(value)), []byte(key.Hash)) != 1 {
+			return nil, unauthorized("Invalid API key")
+		}
+		if key.RevokedAt != nil {
+			return nil, unauthorized("API key has been revoked")
+		}
+
+		now := time.Now()
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+			if err := keys.Touch(c.Request.Context(), key.ID, now); err != nil {
+				log.Error("Failed to record API key use: ", err)
+			}
+		}
+		return &models.Claims{
+			Username: models.APIKeyUserPrefix + key.Name,
+			Role:     key.Role,
+			Scope:    strings.Join(key.Scopes, " "),
+		}, nil
+	}
+}
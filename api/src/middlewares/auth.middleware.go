@@ -1,9 +1,10 @@
 package middlewares
 
 import (
+	"context"
 	"microservice/src/models"
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
@@ -11,26 +12,106 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// ClaimsKey is the gin context key under which AuthMiddleware stores the
+// *models.Claims of the request's token.
+const ClaimsKey = "claims"
+
+// Claims returns the claims of the authenticated request, or nil on routes
+// without AuthMiddleware.
+func Claims(c *gin.Context) *models.Claims {
+	claims, _ := c.Get(ClaimsKey)
+	if claims, ok := claims.(*models.Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+// Authenticator identifies the caller of a request from the credentials
+// it understands. It returns neither claims nor an error when the request
+// carries none, so the next authenticator can try.
+type Authenticator func(c *gin.Context) (*models.Claims, error)
+
+// AuthError rejects a request's credentials.
+type AuthError struct {
+	Status  int
+	Message string
+}
+
+func (err *AuthError) Error() string {
+	return err.Message
+}
+
+func unauthorized(message string) *AuthError {
+	return &AuthError{Status: http.StatusUnauthorized, Message: message}
+}
+
+// AuthMiddleware authenticates requests with the first of authenticators
+// that recognizes their credentials, and rejects anonymous requests.
+func AuthMiddleware(authenticators ...Authenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		for _, authenticate := range authenticators {
+			claims, err := authenticate(c)
+			if authErr, ok := err.(*AuthError); ok {
+				c.AbortWithStatusJSON(authErr.Status, gin.H{"error": authErr.Message})
+				return
+			}
+			if err != nil {
+				// Failing open could let revoked credentials through.
+				log.Error("Failed to authenticate request: ", err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Cannot authenticate requests at the moment"})
+				return
+			}
+			if claims == nil {
+				continue
+			}
+
+			// Tokens issued before roles and scopes get the defaults.
+			if claims.Role == "" {
+				claims.Role = models.DefaultRole
+			}
+			if claims.Scope == "" {
+				claims.Scope = strings.Join(ScopesForRole(claims.Role), " ")
+			}
+			c.Set(ClaimsKey, claims)
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	}
+}
+
+// Revocations tells whether an access token was revoked before it expired,
+// by ID or along with its session.
+type Revocations interface {
+	Revoked(ctx context.Context, tokenID, sessionID string) (bool, error)
+}
+
+// JWTAuthenticator accepts access tokens, in the Authorization header,
+// verified by keyfunc and not revoked. revocations may be nil when tokens
+// cannot be revoked.
+func JWTAuthenticator(keyfunc jwt.Keyfunc, revocations Revocations) Authenticator {
+	return func(c *gin.Context) (*models.Claims, error) {
 		tokenValue := c.GetHeader("Authorization")
+		if tokenValue == "" {
+			return nil, nil
+		}
 
-		log.Debug("tokenValue", tokenValue)
 		claims := &models.Claims{}
 
-		tkn, err := jwt.ParseWithClaims(tokenValue, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+		tkn, err := jwt.ParseWithClaims(tokenValue, claims, keyfunc)
+		if err != nil || !tkn.Valid {
+			return nil, unauthorized("Invalid token")
 		}
 
-		if !tkn.Valid {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+		if revocations != nil {
+			revoked, err := revocations.Revoked(c.Request.Context(), claims.Id, claims.Session)
+			if err != nil {
+				return nil, err
+			}
+			if revoked {
+				return nil, unauthorized("Token has been revoked")
+			}
 		}
-
-		c.Next()
+		return claims, nil
 	}
 }
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sensitiveParams are query parameters carrying credentials: email
+// verification tokens, and the authorization code and state of OpenID
+// Connect callbacks.
+var sensitiveParams = []string{"token", "code", "state"}
+
+// LoggerMiddleware logs requests like gin.Logger, with the values of
+// sensitiveParams masked.
+func LoggerMiddleware() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		var statusColor, methodColor, resetColor string
+		if param.IsOutputColor() {
+			statusColor = param.StatusCodeColor()
+			methodColor = param.MethodColor()
+			resetColor = param.ResetColor()
+		}
+		if param.Latency > time.Minute {
+			param.Latency = param.Latency - param.Latency%time.Second
+		}
+		return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			statusColor, param.StatusCode, resetColor,
+			param.Latency,
+			param.ClientIP,
+			methodColor, param.Method, resetColor,
+			redactQuery(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactQuery masks the values of sensitiveParams in a request path.
+func redactQuery(path string) string {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path
+	}
+	query, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		return path[:i] + "?REDACTED"
+	}
+	redacted := false
+	for _, name := range sensitiveParams {
+		if _, ok := query[name]; ok {
+			query[name] = []string{"REDACTED"}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	return path[:i] + "?" + query.Encode()
+}
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactQuery(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/recipes":                        "/recipes",
+		"/recipes?tag=vegan&limit=2":      "/recipes?tag=vegan&limit=2",
+		"/verify-email?token=abc":         "/verify-email?token=REDACTED",
+		"/oidc/callback?code=c1&state=s1": "/oidc/callback?code=REDACTED&state=REDACTED",
+		"/verify-email?token=a%zz":        "/verify-email?REDACTED",
+		"/oidc/callback?error=denied&x=1": "/oidc/callback?error=denied&x=1",
+	} {
+		assert.Equal(t, expected, redactQuery(path), path)
+	}
+}
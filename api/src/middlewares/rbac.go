@@ -0,0 +1,116 @@
+package middlewares
+
+import (
+	"fmt"
+	"microservice/src/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission is an action on the API that roles may be granted.
+type Permission string
+
+const (
+	// PermissionRecipesRead covers reads that need a token, such as
+	// revision history.
+	PermissionRecipesRead Permission = "recipes:read"
+	// PermissionRecipesWrite allows creating recipes and changing one's own.
+	PermissionRecipesWrite Permission = "recipes:write"
+	// PermissionRecipesModerate allows changing anyone's recipes and
+	// browsing the trash.
+	PermissionRecipesModerate Permission = "recipes:moderate"
+	// PermissionUsersManage allows administering users and credentials.
+	PermissionUsersManage Permission = "users:manage"
+)
+
+// rolePermissions is the permission matrix. Each role includes the
+// permissions of the roles below it.
+var rolePermissions = map[string][]Permission{
+	models.RoleViewer:      {PermissionRecipesRead},
+	models.RoleContributor: {PermissionRecipesRead, PermissionRecipesWrite},
+	models.RoleEditor:      {PermissionRecipesRead, PermissionRecipesWrite, PermissionRecipesModerate},
+	models.RoleAdmin:       {PermissionRecipesRead, PermissionRecipesWrite, PermissionRecipesModerate, PermissionUsersManage},
+}
+
+// permissionScopes is the token scope each permission requires on top of
+// the role granting it.
+var permissionScopes = map[Permission]string{
+	PermissionRecipesRead:     models.ScopeRecipesRead,
+	PermissionRecipesWrite:    models.ScopeRecipesWrite,
+	PermissionRecipesModerate: models.ScopeRecipesWrite,
+	PermissionUsersManage:     models.ScopeAdmin,
+}
+
+// ScopesForRole returns the scopes needed to use every permission of role,
+// in the order of models.Scopes. Sign-in tokens carry all of them.
+func ScopesForRole(role string) []string {
+	needed := make(map[string]bool)
+	for _, permission := range rolePermissions[role] {
+		needed[permissionScopes[permission]] = true
+	}
+	scopes := make([]string, 0, len(needed))
+	for _, scope := range models.Scopes {
+		if needed[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// HasPermission reports whether the role in claims grants permission and
+// the token carries the scope it requires.
+func HasPermission(claims *models.Claims, permission Permission) bool {
+	return roleGrants(claims, permission) && claims.HasScope(permissionScopes[permission])
+}
+
+func roleGrants(claims *models.Claims, permission Permission) bool {
+	if claims == nil {
+		return false
+	}
+	for _, granted := range rolePermissions[claims.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole only lets through tokens holding one of roles. It must run
+// after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := Claims(c)
+		if claims != nil {
+			for _, role := range roles {
+				if claims.Role == role {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action requires one of the roles: " + strings.Join(roles, ", ")})
+	}
+}
+
+// RequirePermission only lets through tokens whose role grants permission
+// and that carry the matching scope. A missing scope is reported as in
+// RFC 6750, so clients know which token to ask for. It must run after
+// AuthMiddleware.
+func RequirePermission(permission Permission) gin.HandlerFunc {
+	scope := permissionScopes[permission]
+	return func(c *gin.Context) {
+		claims := Claims(c)
+		if !roleGrants(claims, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + string(permission)})
+			return
+		}
+		if !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is missing scope " + scope, "scope": scope})
+			return
+		}
+		c.Next()
+	}
+}
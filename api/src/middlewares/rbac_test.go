@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"microservice/src/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissionMatrix(t *testing.T) {
+	matrix := map[string]map[Permission]bool{
+		models.RoleViewer: {
+			PermissionRecipesRead: true,
+		},
+		models.RoleContributor: {
+			PermissionRecipesRead:  true,
+			PermissionRecipesWrite: true,
+		},
+		models.RoleEditor: {
+			PermissionRecipesRead:     true,
+			PermissionRecipesWrite:    true,
+			PermissionRecipesModerate: true,
+		},
+		models.RoleAdmin: {
+			PermissionRecipesRead:     true,
+			PermissionRecipesWrite:    true,
+			PermissionRecipesModerate: true,
+			PermissionUsersManage:     true,
+		},
+		"":          {},
+		"superuser": {},
+	}
+	permissions := []Permission{PermissionRecipesRead, PermissionRecipesWrite, PermissionRecipesModerate, PermissionUsersManage}
+
+	for role, granted := range matrix {
+		claims := &models.Claims{Username: "test", Role: role, Scope: "recipes:read recipes:write admin"}
+		for _, permission := range permissions {
+			assert.Equal(t, granted[permission], HasPermission(claims, permission), "%q %s", role, permission)
+		}
+	}
+	assert.False(t, HasPermission(nil, PermissionRecipesRead))
+
+	readOnly := &models.Claims{Role: models.RoleAdmin, Scope: models.ScopeRecipesRead}
+	assert.True(t, HasPermission(readOnly, PermissionRecipesRead))
+	assert.False(t, HasPermission(readOnly, PermissionRecipesWrite))
+}
+
+func TestScopesForRole(t *testing.T) {
+	assert.Equal(t, []string{"recipes:read"}, ScopesForRole(models.RoleViewer))
+	assert.Equal(t, []string{"recipes:read", "recipes:write"}, ScopesForRole(models.RoleEditor))
+	assert.Equal(t, []string{"recipes:read", "recipes:write", "admin"}, ScopesForRole(models.RoleAdmin))
+	assert.Empty(t, ScopesForRole("superuser"))
+}
+
+func performWithClaims(handler gin.HandlerFunc, claims *models.Claims) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		if claims != nil {
+			c.Set(ClaimsKey, claims)
+		}
+	}, handler, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission(PermissionRecipesModerate)
+
+	w := performWithClaims(handler, &models.Claims{Role: models.RoleEditor, Scope: models.ScopeRecipesWrite})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = performWithClaims(handler, &models.Claims{Role: models.RoleContributor, Scope: models.ScopeRecipesWrite})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "recipes:moderate")
+
+	w = performWithClaims(handler, &models.Claims{Role: models.RoleEditor, Scope: models.ScopeRecipesRead})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `scope="recipes:write"`)
+
+	w = performWithClaims(handler, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(models.RoleAdmin, models.RoleEditor)
+
+	w := performWithClaims(handler, &models.Claims{Role: models.RoleEditor})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = performWithClaims(handler, &models.Claims{Role: models.RoleViewer})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
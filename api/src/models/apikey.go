@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyUserPrefix starts the username requests made with an API key act
+// as. Usernames of accounts cannot contain a colon.
+const APIKeyUserPrefix = "apikey:"
+
+// APIKey lets a machine client, such as a cron job, call the API without a
+// user account. Requests made with it act as the user "apikey:<name>".
+// Only a hash of the key is stored; Prefix finds it.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"-" bson:"hash"`
+	Role       string             `json:"role" bson:"role"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedBy  string             `json:"createdBy" bson:"createdBy"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// APIKeyRequest issues an API key. Role defaults to DefaultRole and Scopes
+// to every scope of the role.
+type APIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+}
+
+// NewAPIKey is returned once, when the key is issued. Key cannot be
+// retrieved afterwards.
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyList struct {
+	Keys []APIKey `json:"keys"`
+}
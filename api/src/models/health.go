@@ -0,0 +1,8 @@
+package models
+
+// Health reports the overall status ("ok", "degraded" or "down") and the
+// state of each backing service ("up", "down" or "disabled").
+type Health struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
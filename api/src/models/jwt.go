@@ -6,12 +6,47 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+// Claims of access tokens. Every token has an ID (jti) so it can be
+// revoked; tokens issued at sign in or refresh also name their session, so
+// signing out revokes them all. Minted marks down-scoped tokens from
+// POST /tokens.
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Session  string `json:"sid,omitempty"`
+	Minted   bool   `json:"minted,omitempty"`
 	jwt.StandardClaims
 }
 
 type JWTOutput struct {
 	Token   string    `json:"token"`
 	Expires time.Time `json:"expires"`
+	// RefreshToken is returned by sign in and refresh, and can be used
+	// once to get the next access token.
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// RefreshRequest exchanges a refresh token for new tokens.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// JWKSet publishes the public keys verifying access tokens.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a public key in JSON Web Key form. RSA keys have N and E, EC keys
+// Curve, X and Y.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
 }
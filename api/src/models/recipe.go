@@ -14,5 +14,16 @@ type Recipe struct {
 	Tags         []string           `json:"tags" bson:"tags"`
 	Ingredients  []string           `json:"ingredients" bson:"ingredients"`
 	Instructions []string           `json:"instructions" bson:"instructions"`
+	Author       string             `json:"author" bson:"author"`
 	PublishedAt  time.Time          `json:"publishedAt" bson:"publishedAt"`
+	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+	Version      int64              `json:"version" bson:"version"`
+	DeletedAt    *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy    string             `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+}
+
+// RecipeList is a single page of recipes. NextCursor is empty on the last page.
+type RecipeList struct {
+	Recipes    []Recipe `json:"recipes"`
+	NextCursor string   `json:"next_cursor,omitempty"`
 }
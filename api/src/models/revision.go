@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision is an immutable snapshot of a recipe taken after a write. Its
+// number is the recipe version the write produced.
+type Revision struct {
+	RecipeID  primitive.ObjectID `json:"recipeId" bson:"recipeId"`
+	Number    int64              `json:"revision" bson:"revision"`
+	Author    string             `json:"author" bson:"author"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	Recipe    *Recipe            `json:"recipe,omitempty" bson:"recipe,omitempty"`
+}
+
+// RevisionList holds the revisions of a recipe, newest first, without their
+// snapshots.
+type RevisionList struct {
+	Revisions []Revision `json:"revisions"`
+}
+
+// FieldChange is one field that differs between two revisions. Added and
+// Removed list the items that changed in list fields.
+type FieldChange struct {
+	Field   string      `json:"field"`
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// RevisionDiff lists the fields changed between two revisions of a recipe.
+type RevisionDiff struct {
+	From    int64         `json:"from"`
+	To      int64         `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// RevertRequest is the optional body of a revert, naming the recipe version
+// being replaced when no If-Match header is sent.
+type RevertRequest struct {
+	Version int64 `json:"version"`
+}
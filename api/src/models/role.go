@@ -0,0 +1,14 @@
+package models
+
+// Roles a user can hold, from most to least privileged. The permissions
+// each one grants are defined in middlewares.
+const (
+	RoleAdmin       = "admin"
+	RoleEditor      = "editor"
+	RoleContributor = "contributor"
+	RoleViewer      = "viewer"
+)
+
+// DefaultRole is assumed for users and tokens that predate roles, which
+// could already create and edit their own recipes.
+const DefaultRole = RoleContributor
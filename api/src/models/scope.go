@@ -0,0 +1,31 @@
+package models
+
+import "strings"
+
+// OAuth style scopes carried, space separated, in the scope claim of access
+// tokens. A token never holds a scope its user's role does not grant.
+const (
+	ScopeRecipesRead  = "recipes:read"
+	ScopeRecipesWrite = "recipes:write"
+	ScopeAdmin        = "admin"
+)
+
+// Scopes lists every scope a token can carry.
+var Scopes = []string{ScopeRecipesRead, ScopeRecipesWrite, ScopeAdmin}
+
+// ScopedTokenRequest asks for a token restricted to some of the caller's
+// scopes, valid for ExpiresIn seconds.
+type ScopedTokenRequest struct {
+	Scopes    []string `json:"scopes" binding:"required"`
+	ExpiresIn int64    `json:"expires_in"`
+}
+
+// HasScope reports whether the token carries scope.
+func (claims *Claims) HasScope(scope string) bool {
+	for _, granted := range strings.Fields(claims.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+package models
+
+// RecipeSearchHit is a recipe matching a search query. Highlights maps a
+// field name to snippets of that field with the matched words in <em> tags.
+type RecipeSearchHit struct {
+	Recipe     Recipe              `json:"recipe"`
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights"`
+}
+
+type RecipeSearchResults struct {
+	Query   string            `json:"query"`
+	Results []RecipeSearchHit `json:"results"`
+}
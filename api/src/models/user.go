@@ -1,6 +1,44 @@
 package models
 
+import "time"
+
 type User struct {
 	Password string `json:"password"`
 	Username string `json:"username"`
+	// Role is read from the users collection, never from requests.
+	Role string `json:"-" bson:"role,omitempty"`
+	// Email is unset for users created before sign up existed, who are
+	// treated as verified.
+	Email         string `json:"-" bson:"email,omitempty"`
+	EmailVerified bool   `json:"-" bson:"emailVerified,omitempty"`
+	// Verification is the pending email verification, if any.
+	Verification *EmailVerification `json:"-" bson:"verification,omitempty"`
+	CreatedAt    time.Time          `json:"-" bson:"createdAt,omitempty"`
+	// OIDC links the user to an account at the OpenID Connect provider.
+	OIDC *ExternalIdentity `json:"-" bson:"oidc,omitempty"`
+}
+
+// ExternalIdentity is a user of an OpenID Connect provider.
+type ExternalIdentity struct {
+	Issuer  string `bson:"issuer"`
+	Subject string `bson:"subject"`
+}
+
+// EmailVerification holds the SHA-256 of the token mailed to a new user.
+// The token itself is never stored.
+type EmailVerification struct {
+	TokenHash string    `bson:"tokenHash"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// SignUpRequest registers a new user.
+type SignUpRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ResendVerificationRequest asks for a new verification email.
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
 }
@@ -0,0 +1,192 @@
+// Package oidc signs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE (RFC 7636).
+//
+// The provider is discovered from its issuer URL on first use. Its ID
+// tokens are verified against the keys it publishes, which are fetched
+// again when a token names an unknown key.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config identifies the API as a client of the provider at Issuer.
+// ClientSecret may be empty for public clients.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// metadata is the part of the provider's discovery document the flow needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider the API is registered with.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        map[string]interface{}
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		config: config,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// discover returns the provider metadata, fetching it once.
+func (provider *Provider) discover(ctx context.Context) (*metadata, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.metadata != nil {
+		return provider.metadata, nil
+	}
+
+	var discovered metadata
+	wellKnown := strings.TrimSuffix(provider.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := provider.getJSON(ctx, wellKnown, &discovered); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %v", err)
+	}
+	// OpenID Connect Discovery section 4.3.
+	if discovered.Issuer != provider.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer is %q, expected %q", discovered.Issuer, provider.config.Issuer)
+	}
+	if discovered.AuthorizationEndpoint == "" || discovered.TokenEndpoint == "" || discovered.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: missing endpoints")
+	}
+	provider.metadata = &discovered
+	return provider.metadata, nil
+}
+
+// AuthCodeURL is where to send the user to sign in. state and nonce are
+// checked on return, verifier is kept until the code is exchanged.
+func (provider *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	discovered, err := provider.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.config.ClientID},
+		"redirect_uri":          {provider.config.RedirectURL},
+		"scope":                 {strings.Join(provider.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovered.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovered.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the user's ID token, unverified.
+func (provider *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	discovered, err := provider.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.config.RedirectURL},
+		"client_id":     {provider.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovered.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if provider.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.config.ClientID), url.QueryEscape(provider.config.ClientSecret))
+	}
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("oidc: token endpoint returned %s", response.Status)
+	}
+	if tokens.Error != "" {
+		return "", fmt.Errorf("oidc: token endpoint: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if response.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("oidc: token endpoint returned %s without an ID token", response.Status)
+	}
+	return tokens.IDToken, nil
+}
+
+func (provider *Provider) getJSON(ctx context.Context, url string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, response.Body)
+		return fmt.Errorf("GET %s returned %s", url, response.Status)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value)
+}
+
+// RandomString returns 256 random bits, URL safe, for states, nonces and
+// PKCE verifiers.
+func RandomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Challenge derives the S256 PKCE challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"microservice/src/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const redirectURL = "https://recipes.example.com/oidc/callback"
+
+func newProvider(idp *oidctest.IdP) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  redirectURL,
+	}, idp.Server.Client())
+}
+
+// authorize follows the authorization URL and returns the code and state
+// the provider redirects back with.
+func authorize(t *testing.T, provider *Provider, nonce, verifier string) (string, string) {
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	assert.Nil(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(authURL)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "recipes.example.com", location.Host)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func signIn(t *testing.T, provider *Provider) (*IDToken, error) {
+	verifier, _ := RandomString()
+	code, state := authorize(t, provider, "nonce-1", verifier)
+	assert.Equal(t, "state-1", state)
+	raw, err := provider.Exchange(context.Background(), code, verifier)
+	assert.Nil(t, err)
+	return provider.Verify(context.Background(), raw, "nonce-1")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	idp.SignInAs(oidctest.User{Subject: "u-42", Email: "cook@example.com", EmailVerified: true, PreferredUsername: "cook"})
+	provider := newProvider(idp)
+
+	token, err := signIn(t, provider)
+	assert.Nil(t, err)
+	assert.Equal(t, idp.Issuer(), token.Issuer)
+	assert.Equal(t, "u-42", token.Subject)
+	assert.Equal(t, "cook@example.com", token.Email)
+	assert.True(t, token.EmailVerified)
+	assert.Equal(t, "cook", token.PreferredUsername)
+
+	// The provider rotated its key: the new one is fetched.
+	idp.RotateKey("stub-2")
+	provider.keysFetched = time.Now().Add(-time.Hour)
+	_, err = signIn(t, provider)
+	assert.Nil(t, err)
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	provider := newProvider(idp)
+
+	verifier, _ := RandomString()
+	code, _ := authorize(t, provider, "nonce-1", verifier)
+	_, err := provider.Exchange(context.Background(), code, "another-verifier")
+	assert.NotNil(t, err)
+
+	// Codes are single use.
+	_, err = provider.Exchange(context.Background(), code, verifier)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "s3cret")
+	defer idp.Close()
+	idp.SignInAs(oidctest.User{Subject: "u-42"})
+	provider := newProvider(idp)
+
+	for name, claims := range map[string]map[string]interface{}{
+		"audience":        {"aud": "another-client"},
+		"authorized part": {"aud": []string{"recipes", "another-client"}, "azp": "another-client"},
+		"issuer":          {"iss": "https://evil.example.com"},
+		"expired":         {"exp": time.Now().Add(-time.Hour).Unix()},
+		"future":          {"iat": time.Now().Add(time.Hour).Unix()},
+		"nonce":           {"nonce": "replayed"},
+		"subject":         {"sub": ""},
+	} {
+		idp.Claims = claims
+		_, err := signIn(t, provider)
+		assert.True(t, errors.Is(err, ErrInvalidIDToken), name)
+	}
+
+	idp.Claims = map[string]interface{}{"aud": []string{"another-client", "recipes"}, "azp": "recipes"}
+	_, err := signIn(t, provider)
+	assert.Nil(t, err)
+
+	// Unknown keys are not fetched again right away.
+	idp.Claims = nil
+	idp.RotateKey("stub-2")
+	_, err = signIn(t, provider)
+	assert.True(t, errors.Is(err, ErrInvalidIDToken))
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	idp := oidctest.NewIdP("recipes", "")
+	defer idp.Close()
+	provider := NewProvider(Config{Issuer: idp.Issuer() + "/", ClientID: "recipes", RedirectURL: redirectURL}, idp.Server.Client())
+
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	assert.NotNil(t, err)
+}
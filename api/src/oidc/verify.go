@@ -0,0 +1,188 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"microservice/src/models"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// leeway tolerates clock skew between the API and the provider.
+	leeway = time.Minute
+	// keysRefreshInterval limits how often unknown key IDs make the
+	// provider's keys be fetched again.
+	keysRefreshInterval = time.Minute
+)
+
+// ErrInvalidIDToken is returned when an ID token does not verify.
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+// IDToken holds the claims of a verified ID token the API uses.
+type IDToken struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid is called by jwt.Parse, which cannot check times with leeway.
+func (token *IDToken) Valid() error {
+	return nil
+}
+
+// audience is a single string or an array of them.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*aud = multiple
+	return nil
+}
+
+func (aud audience) contains(value string) bool {
+	for _, candidate := range aud {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks an ID token as in OpenID Connect Core section 3.1.3.7: its
+// signature, issuer, audience, lifetime and nonce.
+func (provider *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	discovered, err := provider.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &IDToken{}
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "ES256"}}
+	_, err = parser.ParseWithClaims(raw, token, func(parsed *jwt.Token) (interface{}, error) {
+		id, _ := parsed.Header["kid"].(string)
+		return provider.key(ctx, discovered, id)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := provider.now()
+	switch {
+	case token.Issuer != discovered.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, token.Issuer)
+	case !token.Audience.contains(provider.config.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(token.Audience) > 1 && token.AuthorizedParty != provider.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, token.AuthorizedParty)
+	case token.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case now.After(time.Unix(token.ExpiresAt, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case time.Unix(token.IssuedAt, 0).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case nonce == "" || token.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return token, nil
+}
+
+// key returns the provider's public key with the given ID, fetching the
+// key set again if it is unknown.
+func (provider *Provider) key(ctx context.Context, discovered *metadata, id string) (interface{}, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, ok := provider.keys[id]; ok {
+		return key, nil
+	}
+	if provider.keys != nil && provider.now().Sub(provider.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	var set models.JWKSet
+	if err := provider.getJSON(ctx, discovered.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := publicKey(jwk); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	provider.keys = keys
+	provider.keysFetched = provider.now()
+
+	if key, ok := keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", id)
+}
+
+// publicKey decodes an RSA or P-256 JWK.
+func publicKey(jwk models.JWK) (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,106 @@
+// Package password hashes user passwords with argon2id and verifies them
+// against both current and legacy stored hashes.
+//
+// Hashes are stored in the PHC string format,
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+//
+// which records the algorithm and its parameters, so they can be raised
+// later and existing hashes upgraded as users sign in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrMalformedHash is returned when a stored argon2id hash cannot be parsed.
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// params are the argon2id cost parameters. Changing them makes Verify
+// report older hashes as needing a rehash.
+type params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+var current = params{memory: 64 * 1024, time: 3, threads: 4}
+
+const (
+	prefix    = "$argon2id$"
+	saltBytes = 16
+	keyBytes  = 32
+)
+
+// Hash returns a salted argon2id hash of password in PHC string format.
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, current.time, current.memory, current.threads, keyBytes)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", prefix, argon2.Version,
+		current.memory, current.time, current.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against a stored hash. rehash is true when the
+// password matches but the hash is a legacy one or uses outdated
+// parameters, and should be replaced by Hash(password).
+func Verify(password, stored string) (ok, rehash bool, err error) {
+	if !strings.HasPrefix(stored, prefix) {
+		return verifyLegacy(password, stored), true, nil
+	}
+
+	hashParams, salt, key, err := decode(stored)
+	if err != nil {
+		return false, false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, hashParams.time, hashParams.memory, hashParams.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+	return true, hashParams != current, nil
+}
+
+// verifyLegacy checks the format written before argon2id, which was
+// sha256.New().Sum([]byte(password)): the password itself followed by the
+// SHA-256 of nothing. It is not a hash at all, hence the rehash.
+func verifyLegacy(password, stored string) bool {
+	legacy := sha256.New().Sum([]byte(password))
+	return subtle.ConstantTimeCompare(legacy, []byte(stored)) == 1
+}
+
+func decode(stored string) (params, []byte, []byte, error) {
+	var hashParams params
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return hashParams, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return hashParams, nil, nil, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hashParams.memory, &hashParams.time, &hashParams.threads); err != nil {
+		return hashParams, nil, nil, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return hashParams, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return hashParams, nil, nil, ErrMalformedHash
+	}
+	return hashParams, salt, key, nil
+}
@@ -0,0 +1,63 @@
+package password
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash("fCRmh4Q2J7Rseqkz")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$"))
+
+	other, _ := Hash("fCRmh4Q2J7Rseqkz")
+	assert.NotEqual(t, hash, other, "hashes must be salted")
+
+	ok, rehash, err := Verify("fCRmh4Q2J7Rseqkz", hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = Verify("wrong", hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	legacy := string(sha256.New().Sum([]byte("RE4zfHB35VPtTkbT")))
+
+	ok, rehash, err := Verify("RE4zfHB35VPtTkbT", legacy)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, _, _ = Verify("RE4zfHB35VPtTkb", legacy)
+	assert.False(t, ok)
+}
+
+func TestVerifyOutdatedParameters(t *testing.T) {
+	saved := current
+	current = params{memory: 8 * 1024, time: 1, threads: 1}
+	hash, _ := Hash("secret")
+	current = saved
+
+	ok, rehash, err := Verify("secret", hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	for _, stored := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=lots,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$!!!",
+	} {
+		_, _, err := Verify("secret", stored)
+		assert.Equal(t, ErrMalformedHash, err, stored)
+	}
+}
@@ -0,0 +1,96 @@
+package patch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	// Test cases from RFC 7396 appendix A.
+	cases := []struct {
+		document, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		result, err := Merge([]byte(c.document), []byte(c.patch))
+		assert.Nil(t, err)
+		assert.JSONEq(t, c.result, string(result), "%s + %s", c.document, c.patch)
+	}
+
+	_, err := Merge([]byte(`{}`), []byte(`{`))
+	assert.True(t, errors.Is(err, ErrInvalidPatch))
+}
+
+func TestApply(t *testing.T) {
+	// Mostly test cases from RFC 6902 appendix A.
+	cases := []struct {
+		document, patch, result string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":["a","b"]}`, `[{"op":"replace","path":"/foo/1","value":"c"}]`, `{"foo":["a","c"]}`},
+		{
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":["a"]}`, `[{"op":"copy","from":"/foo","path":"/bar"},{"op":"add","path":"/bar/-","value":"b"}]`, `{"foo":["a"],"bar":["a","b"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	}
+	for _, c := range cases {
+		result, err := Apply([]byte(c.document), []byte(c.patch))
+		assert.Nil(t, err, c.patch)
+		assert.JSONEq(t, c.result, string(result), c.patch)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	cases := []struct {
+		patch string
+		err   error
+	}{
+		{`{"op":"add"}`, ErrInvalidPatch},
+		{`[{"op":"frobnicate","path":"/a"}]`, ErrInvalidPatch},
+		{`[{"op":"add","path":"a","value":1}]`, ErrInvalidPatch},
+		{`[{"op":"add","path":"/a"}]`, ErrInvalidPatch},
+		{`[{"op":"add","path":"/missing/b","value":1}]`, ErrInvalidPatch},
+		{`[{"op":"add","path":"/list/5","value":1}]`, ErrInvalidPatch},
+		{`[{"op":"add","path":"/list/01","value":1}]`, ErrInvalidPatch},
+		{`[{"op":"remove","path":"/missing"}]`, ErrInvalidPatch},
+		{`[{"op":"replace","path":"/list/2","value":1}]`, ErrInvalidPatch},
+		{`[{"op":"move","from":"/obj","path":"/obj/child"}]`, ErrInvalidPatch},
+		{`[{"op":"test","path":"/a","value":"2"}]`, ErrTestFailed},
+		{`[{"op":"add","path":"/b","value":1},{"op":"test","path":"/a","value":2}]`, ErrTestFailed},
+	}
+	document := []byte(`{"a":1,"list":["x","y"],"obj":{}}`)
+	for _, c := range cases {
+		_, err := Apply(document, []byte(c.patch))
+		assert.True(t, errors.Is(err, c.err), "%s: %v", c.patch, err)
+	}
+}
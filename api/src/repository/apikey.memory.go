@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAPIKeyRepository keeps API keys in process memory, next to
+// MemoryUserRepository.
+type MemoryAPIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[primitive.ObjectID]models.APIKey
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{
+		keys: make(map[primitive.ObjectID]models.APIKey),
+	}
+}
+
+func (repository *MemoryAPIKeyRepository) Create(ctx context.Context, key models.APIKey) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for _, existing := range repository.keys {
+		if existing.Name == key.Name {
+			return ErrAPIKeyExists
+		}
+	}
+	repository.keys[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+func (repository *MemoryAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0, len(repository.keys))
+	for _, key := range repository.keys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (repository *MemoryAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	for _, key := range repository.keys {
+		if key.Prefix == prefix {
+			return copyAPIKey(key), nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+func (repository *MemoryAPIKeyRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (models.APIKey, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key, ok := repository.keys[id]
+	if !ok {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		repository.keys[id] = key
+	}
+	return copyAPIKey(key), nil
+}
+
+func (repository *MemoryAPIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key, ok := repository.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = &at
+	repository.keys[id] = key
+	return nil
+}
+
+func copyAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		key.LastUsedAt = &lastUsedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		key.RevokedAt = &revokedAt
+	}
+	return key
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoAPIKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoAPIKeyRepository(collection *mongo.Collection) *MongoAPIKeyRepository {
+	return &MongoAPIKeyRepository{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes makes prefixes unique, since they identify keys, and names,
+// since requests act as them.
+func (repository *MongoAPIKeyRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repository.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+func (repository *MongoAPIKeyRepository) Create(ctx context.Context, key models.APIKey) error {
+	_, err := repository.collection.InsertOne(ctx, key)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAPIKeyExists
+	}
+	return err
+}
+
+func (repository *MongoAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	cur, err := repository.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	keys := make([]models.APIKey, 0)
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (repository *MongoAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	var key models.APIKey
+	err := repository.collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return key, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (repository *MongoAPIKeyRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (models.APIKey, error) {
+	_, err := repository.collection.UpdateOne(ctx, bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"revokedAt": at},
+	})
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	var key models.APIKey
+	err = repository.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return key, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (repository *MongoAPIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := repository.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"lastUsedAt": at},
+	})
+	return err
+}
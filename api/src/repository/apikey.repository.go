@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microservice/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrAPIKeyNotFound is returned when no API key has the requested ID
+	// or prefix.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrAPIKeyExists is returned by Create when a key, even revoked, has
+	// the same name.
+	ErrAPIKeyExists = errors.New("an API key with this name already exists")
+)
+
+// APIKeyRepository stores API keys. Revoked keys are kept, for auditing.
+type APIKeyRepository interface {
+	// Create fails with ErrAPIKeyExists if the name is taken. Requests made
+	// with a key act as its name, so a name is never given out twice.
+	Create(ctx context.Context, key models.APIKey) error
+	// List returns every key, newest first.
+	List(ctx context.Context) ([]models.APIKey, error)
+	FindByPrefix(ctx context.Context, prefix string) (models.APIKey, error)
+	// Revoke marks a key revoked, unless it already is, and returns it.
+	Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (models.APIKey, error)
+	// Touch records that a key was used.
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
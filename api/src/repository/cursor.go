@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"microservice/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned when a client supplied cursor cannot be decoded
+// or was issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the position of the last recipe returned in a page. It holds
+// the value of the sort field plus _id, so the position is unique and stable.
+type Cursor struct {
+	Sort        string             `json:"s"`
+	PublishedAt time.Time          `json:"p"`
+	Name        string             `json:"n,omitempty"`
+	ID          primitive.ObjectID `json:"id"`
+}
+
+func cursorOf(recipe models.Recipe, query RecipeQuery) *Cursor {
+	cursor := &Cursor{Sort: query.Sort(), ID: recipe.ID}
+	if query.SortBy == SortByName {
+		cursor.Name = recipe.Name
+	} else {
+		cursor.PublishedAt = recipe.PublishedAt
+	}
+	return cursor
+}
+
+// Encode returns the opaque representation handed out to clients.
+func (cursor Cursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	if _, _, err := ParseSort(cursor.Sort); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// newRecipePage trims a result fetched with limit+1 documents and sets the
+// cursor of the next page when the extra document was present.
+func newRecipePage(recipes []models.Recipe, options ListOptions) RecipePage {
+	if len(recipes) <= options.Limit {
+		return RecipePage{Recipes: recipes}
+	}
+	recipes = recipes[:options.Limit]
+	return RecipePage{Recipes: recipes, Next: cursorOf(recipes[len(recipes)-1], options.Query)}
+}
+
+// precedes reports whether the cursor position sorts ahead of recipe, i.e.
+// whether recipe belongs to a page after the cursor.
+func (cursor Cursor) precedes(recipe models.Recipe, query RecipeQuery) bool {
+	position := models.Recipe{PublishedAt: cursor.PublishedAt, Name: cursor.Name, ID: cursor.ID}
+	return query.before(position, recipe)
+}
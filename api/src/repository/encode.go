@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"microservice/src/models"
+)
+
+// EncodedPage is a page of recipes already serialized as a models.RecipeList
+// response body, so it can be cached and served without decoding.
+type EncodedPage struct {
+	Body json.RawMessage `json:"body"`
+	Next *Cursor         `json:"next,omitempty"`
+}
+
+// PageWriter receives a page of recipes from WriteJSON, in one of two
+// ways. A page encoded beforehand, such as a cached one, is passed whole to
+// WritePage. Otherwise the body is written as it is encoded, and Finish is
+// called with the cursor of the next page, known only at the end.
+type PageWriter interface {
+	io.Writer
+	WritePage(page EncodedPage) error
+	Finish(next *Cursor)
+}
+
+// pageRecorder is a PageWriter copying a streamed page, so it can be cached
+// once complete.
+type pageRecorder struct {
+	PageWriter
+	body bytes.Buffer
+	next *Cursor
+}
+
+func (recorder *pageRecorder) Write(p []byte) (int, error) {
+	recorder.body.Write(p)
+	return recorder.PageWriter.Write(p)
+}
+
+func (recorder *pageRecorder) Finish(next *Cursor) {
+	recorder.next = next
+	recorder.PageWriter.Finish(next)
+}
+
+func (recorder *pageRecorder) page() EncodedPage {
+	return EncodedPage{Body: recorder.body.Bytes(), Next: recorder.next}
+}
+
+// discardPage is a PageWriter dropping the page, for pages only recorded.
+type discardPage struct{}
+
+func (discardPage) Write(p []byte) (int, error)      { return len(p), nil }
+func (discardPage) WritePage(page EncodedPage) error { return nil }
+func (discardPage) Finish(next *Cursor)              {}
+
+// encodeRecipeList streams up to options.Limit recipes to w as a
+// models.RecipeList body, one recipe at a time as next produces them, so
+// the page never exists as a slice or as a whole body. next decodes the
+// following recipe into its argument and returns false once the results are
+// exhausted; it is called at most options.Limit+1 times, the extra recipe
+// only telling that a page follows. Nothing is written before the first
+// recipe is read, so a failing query can still be answered with an error.
+func encodeRecipeList(w PageWriter, options ListOptions, next func(recipe *models.Recipe) (bool, error)) error {
+	// Each recipe is encoded on its own, reusing the same buffer.
+	var scratch bytes.Buffer
+	encoder := json.NewEncoder(&scratch)
+	var recipe, last models.Recipe
+	var cursor *Cursor
+	for count := 0; ; count++ {
+		// Cleared rather than declared here, so it is not allocated for
+		// every recipe.
+		recipe = models.Recipe{}
+		ok, err := next(&recipe)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if _, err := io.WriteString(w, `{"recipes":[`); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			break
+		}
+		if count == options.Limit {
+			cursor = cursorOf(last, options.Query)
+			break
+		}
+
+		scratch.Reset()
+		if count > 0 {
+			scratch.WriteByte(',')
+		}
+		if err := encoder.Encode(&recipe); err != nil {
+			return err
+		}
+		// Drop the newline Encode appends after each value.
+		if _, err := w.Write(scratch.Bytes()[:scratch.Len()-1]); err != nil {
+			return err
+		}
+		last = recipe
+	}
+
+	tail := "]"
+	if cursor != nil {
+		encoded, _ := json.Marshal(cursor.Encode())
+		tail += `,"next_cursor":` + string(encoded)
+	}
+	if _, err := io.WriteString(w, tail+"}"); err != nil {
+		return err
+	}
+	w.Finish(cursor)
+	return nil
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PurgeDeleted permanently removes recipes that have been in the trash for
+// longer than retention, along with their revisions, checking every interval
+// until ctx is done. Running it on every replica is harmless since purging
+// is idempotent.
+func PurgeDeleted(ctx context.Context, repository RecipeRepository, revisions RevisionRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := repository.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Error("Failed to purge deleted recipes: ", err)
+		} else if len(purged) > 0 {
+			log.Info("Purged deleted recipes: ", len(purged))
+			if err := revisions.DeleteRecipes(ctx, purged); err != nil {
+				log.Error("Failed to purge revisions of deleted recipes: ", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	repository := NewMemoryRecipeRepository()
+
+	old := models.Recipe{ID: primitive.NewObjectID(), Name: "Old", Version: 1}
+	recent := models.Recipe{ID: primitive.NewObjectID(), Name: "Recent", Version: 1}
+	live := models.Recipe{ID: primitive.NewObjectID(), Name: "Live", Version: 1}
+	for _, recipe := range []models.Recipe{old, recent, live} {
+		assert.Nil(t, repository.Create(ctx, recipe))
+	}
+	_, err := repository.Delete(ctx, old.ID, 1, "admin")
+	assert.Nil(t, err)
+	_, err = repository.Delete(ctx, recent.ID, 1, "admin")
+	assert.Nil(t, err)
+
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	stale := repository.recipes[old.ID]
+	stale.DeletedAt = &deletedAt
+	repository.recipes[old.ID] = stale
+
+	// A cancelled context stops the job after its first pass.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	revisions := NewMemoryRevisionRepository()
+	for _, recipe := range []models.Recipe{old, recent} {
+		assert.Nil(t, revisions.Add(ctx, models.Revision{RecipeID: recipe.ID, Number: 1, Recipe: &recipe}))
+	}
+	PurgeDeleted(cancelled, repository, revisions, time.Hour, time.Minute)
+
+	assert.NotContains(t, repository.recipes, old.ID)
+	assert.NotContains(t, revisions.revisions, old.ID)
+	assert.Contains(t, revisions.revisions, recent.ID)
+	assert.Contains(t, repository.recipes, recent.ID)
+	assert.Contains(t, repository.recipes, live.ID)
+
+	_, err = repository.Restore(ctx, recent.ID)
+	assert.Nil(t, err)
+	_, err = repository.Get(ctx, recent.ID)
+	assert.Nil(t, err)
+	_, err = repository.Restore(ctx, old.ID)
+	assert.Equal(t, ErrRecipeNotFound, err)
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"microservice/src/models"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SortByPublishedAt = "publishedAt"
+	SortByName        = "name"
+)
+
+// ErrInvalidSort is returned when a sort expression names an unknown field.
+var ErrInvalidSort = errors.New("sort must be one of name, -name, publishedAt, -publishedAt")
+
+// RecipeQuery filters and orders the recipes returned by List. The zero
+// value matches every live recipe, newest first. Deleted selects the
+// recipes in the trash instead.
+type RecipeQuery struct {
+	Deleted         bool
+	Tags            []string
+	MatchAllTags    bool
+	Ingredient      string
+	NamePrefix      string
+	PublishedAfter  time.Time
+	PublishedBefore time.Time
+	SortBy          string
+	Ascending       bool
+}
+
+// ParseSort reads a sort expression such as "name" or "-publishedAt"; a
+// leading minus selects descending order.
+func ParseSort(value string) (sortBy string, ascending bool, err error) {
+	ascending = !strings.HasPrefix(value, "-")
+	sortBy = strings.TrimPrefix(value, "-")
+	if sortBy != SortByPublishedAt && sortBy != SortByName {
+		return "", false, ErrInvalidSort
+	}
+	return sortBy, ascending, nil
+}
+
+// Normalize fills defaults and puts the query in canonical form so equal
+// queries compare and hash the same way.
+func (query RecipeQuery) Normalize() RecipeQuery {
+	if query.SortBy == "" {
+		query.SortBy = SortByPublishedAt
+		query.Ascending = false
+	}
+
+	tags := make([]string, 0, len(query.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range query.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	query.Tags = tags
+	if len(tags) < 2 {
+		query.MatchAllTags = false
+	}
+
+	query.Ingredient = strings.ToLower(strings.TrimSpace(query.Ingredient))
+	query.NamePrefix = strings.TrimSpace(query.NamePrefix)
+	return query
+}
+
+// Sort returns the sort expression understood by ParseSort.
+func (query RecipeQuery) Sort() string {
+	if query.Ascending {
+		return query.SortBy
+	}
+	return "-" + query.SortBy
+}
+
+// String encodes a normalized query deterministically. It is used to build
+// cache keys for filtered lists.
+func (query RecipeQuery) String() string {
+	values := url.Values{}
+	values.Set("sort", query.Sort())
+	if query.Deleted {
+		values.Set("deleted", "true")
+	}
+	if len(query.Tags) > 0 {
+		values.Set("tags", strings.Join(query.Tags, ","))
+		if query.MatchAllTags {
+			values.Set("tags_match", "all")
+		}
+	}
+	if query.Ingredient != "" {
+		values.Set("ingredient", query.Ingredient)
+	}
+	if query.NamePrefix != "" {
+		values.Set("name_prefix", query.NamePrefix)
+	}
+	if !query.PublishedAfter.IsZero() {
+		values.Set("published_after", query.PublishedAfter.UTC().Format(time.RFC3339Nano))
+	}
+	if !query.PublishedBefore.IsZero() {
+		values.Set("published_before", query.PublishedBefore.UTC().Format(time.RFC3339Nano))
+	}
+	return values.Encode()
+}
+
+// matches evaluates the filter part of a normalized query against a recipe.
+func (query RecipeQuery) matches(recipe models.Recipe) bool {
+	if (recipe.DeletedAt != nil) != query.Deleted {
+		return false
+	}
+	if len(query.Tags) > 0 {
+		found := 0
+		for _, tag := range query.Tags {
+			if containsString(recipe.Tags, tag) {
+				found++
+			}
+		}
+		if found == 0 || (query.MatchAllTags && found < len(query.Tags)) {
+			return false
+		}
+	}
+	if query.Ingredient != "" {
+		found := false
+		for _, ingredient := range recipe.Ingredients {
+			if strings.Contains(strings.ToLower(ingredient), query.Ingredient) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if query.NamePrefix != "" && !strings.HasPrefix(recipe.Name, query.NamePrefix) {
+		return false
+	}
+	if !query.PublishedAfter.IsZero() && recipe.PublishedAt.Before(query.PublishedAfter) {
+		return false
+	}
+	if !query.PublishedBefore.IsZero() && !recipe.PublishedAt.Before(query.PublishedBefore) {
+		return false
+	}
+	return true
+}
+
+// before reports whether recipe a sorts ahead of b in the query order. Ties
+// on the sort field are broken by _id in the same direction.
+func (query RecipeQuery) before(a, b models.Recipe) bool {
+	cmp := 0
+	switch query.SortBy {
+	case SortByName:
+		cmp = strings.Compare(a.Name, b.Name)
+	default:
+		if a.PublishedAt.Before(b.PublishedAt) {
+			cmp = -1
+		} else if a.PublishedAt.After(b.PublishedAt) {
+			cmp = 1
+		}
+	}
+	if cmp == 0 {
+		cmp = bytes.Compare(a.ID[:], b.ID[:])
+	}
+	if query.Ascending {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
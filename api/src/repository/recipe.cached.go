@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"microservice/src/cache"
+	"microservice/src/models"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recipePagesTag groups every cached page so they can be dropped together
+// when the collection changes.
+const recipePagesTag = "recipes:pages"
+
+var (
+	recipeCacheOptions = cache.Options{
+		TTL:         10 * time.Minute,
+		StaleTTL:    time.Hour,
+		Jitter:      time.Minute,
+		NotFound:    ErrRecipeNotFound,
+		NegativeTTL: 30 * time.Second,
+	}
+	pageCacheOptions = cache.Options{
+		TTL:      time.Minute,
+		StaleTTL: 10 * time.Minute,
+		Jitter:   10 * time.Second,
+		Tags:     []string{recipePagesTag},
+	}
+)
+
+// CachedRecipeRepository caches single recipes and list pages in front of
+// another repository. Every write drops the affected recipe and all cached
+// pages, since any change can move recipes between pages. Expired entries
+// are kept for StaleTTL and served while the store is refreshed, so reads
+// keep working through a MongoDB outage.
+type CachedRecipeRepository struct {
+	store   RecipeRepository
+	recipes *cache.Cache
+	pages   *cache.Cache
+}
+
+func NewCachedRecipeRepository(store RecipeRepository, cacheStore cache.Store) *CachedRecipeRepository {
+	return &CachedRecipeRepository{
+		store:   store,
+		recipes: cache.New("recipe", cacheStore),
+		pages:   cache.New("recipe_list", cacheStore),
+	}
+}
+
+// WithLocalCache adds an in-process tier to both caches, kept coherent with
+// the other replicas through bus.
+func (repository *CachedRecipeRepository) WithLocalCache(capacity int, ttl time.Duration, bus cache.Bus) *CachedRecipeRepository {
+	repository.recipes.WithLocal(capacity, ttl, bus)
+	repository.pages.WithLocal(capacity, ttl, bus)
+	return repository
+}
+
+func (repository *CachedRecipeRepository) List(ctx context.Context, options ListOptions) (RecipePage, error) {
+	options, err := options.normalize()
+	if err != nil {
+		return RecipePage{}, err
+	}
+
+	var page RecipePage
+	err = repository.pages.GetOrLoad(ctx, recipePageKey(options), &page, pageCacheOptions, func(ctx context.Context) (interface{}, error) {
+		return repository.store.List(ctx, options)
+	})
+	return page, err
+}
+
+// WriteJSON caches the encoded body, so hits are written out whole without
+// being decoded. On a miss the page is streamed from the store, and copied
+// as it goes to be cached once complete.
+func (repository *CachedRecipeRepository) WriteJSON(ctx context.Context, options ListOptions, w PageWriter) error {
+	options, err := options.normalize()
+	if err != nil {
+		return err
+	}
+
+	var page EncodedPage
+	ok, save, err := repository.pages.Lookup(ctx, recipePageKey(options)+":json", &page, pageCacheOptions, func(ctx context.Context) (interface{}, error) {
+		recorder := &pageRecorder{PageWriter: discardPage{}}
+		err := repository.store.WriteJSON(ctx, options, recorder)
+		return recorder.page(), err
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		return w.WritePage(page)
+	}
+
+	recorder := &pageRecorder{PageWriter: w}
+	if err := repository.store.WriteJSON(ctx, options, recorder); err != nil {
+		return err
+	}
+	save(recorder.page())
+	return nil
+}
+
+func (repository *CachedRecipeRepository) Get(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	var recipe models.Recipe
+	err := repository.recipes.GetOrLoad(ctx, recipeKey(id), &recipe, recipeCacheOptions, func(ctx context.Context) (interface{}, error) {
+		return repository.store.Get(ctx, id)
+	})
+	return recipe, err
+}
+
+// GetDeleted is not cached; the trash is rarely read.
+func (repository *CachedRecipeRepository) GetDeleted(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	return repository.store.GetDeleted(ctx, id)
+}
+
+func (repository *CachedRecipeRepository) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	return repository.store.Search(ctx, query, limit)
+}
+
+func (repository *CachedRecipeRepository) Create(ctx context.Context, recipe models.Recipe) error {
+	if err := repository.store.Create(ctx, recipe); err != nil {
+		return err
+	}
+	// A negative entry may exist if the ID was looked up before creation.
+	repository.invalidate(recipe.ID)
+	return nil
+}
+
+func (repository *CachedRecipeRepository) Update(ctx context.Context, id primitive.ObjectID, recipe models.Recipe) (models.Recipe, error) {
+	updated, err := repository.store.Update(ctx, id, recipe)
+	repository.invalidate(id)
+	return updated, err
+}
+
+func (repository *CachedRecipeRepository) Delete(ctx context.Context, id primitive.ObjectID, version int64, deletedBy string) (models.Recipe, error) {
+	deleted, err := repository.store.Delete(ctx, id, version, deletedBy)
+	repository.invalidate(id)
+	return deleted, err
+}
+
+func (repository *CachedRecipeRepository) Restore(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	restored, err := repository.store.Restore(ctx, id)
+	repository.invalidate(id)
+	return restored, err
+}
+
+// Purge only affects the trash listing, as purged recipes were already
+// hidden from everything else.
+func (repository *CachedRecipeRepository) Purge(ctx context.Context, before time.Time) ([]primitive.ObjectID, error) {
+	purged, err := repository.store.Purge(ctx, before)
+	if len(purged) > 0 {
+		if err := repository.pages.InvalidateTags(recipePagesTag); err != nil {
+			log.Error("Failed to invalidate cached pages: ", err)
+		}
+	}
+	return purged, err
+}
+
+// invalidate drops a recipe and every cached page. It runs even when the
+// write failed, as the store may have applied it before returning an error.
+func (repository *CachedRecipeRepository) invalidate(id primitive.ObjectID) {
+	if err := repository.recipes.Invalidate(recipeKey(id)); err != nil {
+		log.Error("Failed to invalidate cached recipe: ", err)
+	}
+	if err := repository.pages.InvalidateTags(recipePagesTag); err != nil {
+		log.Error("Failed to invalidate cached pages: ", err)
+	}
+}
+
+func recipeKey(id primitive.ObjectID) string {
+	return "recipe:" + id.Hex()
+}
+
+func recipePageKey(options ListOptions) string {
+	cursor := ""
+	if options.After != nil {
+		cursor = options.After.Encode()
+	}
+	return fmt.Sprintf("recipes:page:%s:%d:%s", options.Query, options.Limit, cursor)
+}
@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"microservice/src/search"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRecipeRepository keeps recipes in process memory. It is meant for
+// tests and for running the API locally without MongoDB.
+type MemoryRecipeRepository struct {
+	mu      sync.RWMutex
+	recipes map[primitive.ObjectID]models.Recipe
+	index   *search.Index
+}
+
+func NewMemoryRecipeRepository() *MemoryRecipeRepository {
+	return &MemoryRecipeRepository{
+		recipes: make(map[primitive.ObjectID]models.Recipe),
+		index:   search.NewIndex(),
+	}
+}
+
+func (repository *MemoryRecipeRepository) List(ctx context.Context, options ListOptions) (RecipePage, error) {
+	options, err := options.normalize()
+	if err != nil {
+		return RecipePage{}, err
+	}
+
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	recipes := repository.selectPage(options)
+	for i := range recipes {
+		recipes[i] = copyRecipe(recipes[i])
+	}
+	return newRecipePage(recipes, options), nil
+}
+
+// WriteJSON copies the page before writing it out, so a slow client does
+// not hold up writers.
+func (repository *MemoryRecipeRepository) WriteJSON(ctx context.Context, options ListOptions, w PageWriter) error {
+	options, err := options.normalize()
+	if err != nil {
+		return err
+	}
+
+	repository.mu.RLock()
+	recipes := repository.selectPage(options)
+	for i := range recipes {
+		recipes[i] = copyRecipe(recipes[i])
+	}
+	repository.mu.RUnlock()
+
+	return encodeRecipeList(w, options, func(recipe *models.Recipe) (bool, error) {
+		if len(recipes) == 0 {
+			return false, nil
+		}
+		*recipe, recipes = recipes[0], recipes[1:]
+		return true, nil
+	})
+}
+
+// selectPage returns up to options.Limit+1 matching recipes in order. The
+// caller holds mu and must copy the recipes before handing them out.
+func (repository *MemoryRecipeRepository) selectPage(options ListOptions) []models.Recipe {
+	recipes := make([]models.Recipe, 0, len(repository.recipes))
+	for _, recipe := range repository.recipes {
+		if !options.Query.matches(recipe) {
+			continue
+		}
+		if options.After != nil && !options.After.precedes(recipe, options.Query) {
+			continue
+		}
+		recipes = append(recipes, recipe)
+	}
+	sort.Slice(recipes, func(i, j int) bool {
+		return options.Query.before(recipes[i], recipes[j])
+	})
+
+	if len(recipes) > options.Limit+1 {
+		recipes = recipes[:options.Limit+1]
+	}
+	return recipes
+}
+
+func (repository *MemoryRecipeRepository) Get(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	recipe, ok := repository.recipes[id]
+	if !ok || recipe.DeletedAt != nil {
+		return models.Recipe{}, ErrRecipeNotFound
+	}
+	return copyRecipe(recipe), nil
+}
+
+func (repository *MemoryRecipeRepository) GetDeleted(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	recipe, ok := repository.recipes[id]
+	if !ok || recipe.DeletedAt == nil {
+		return models.Recipe{}, ErrRecipeNotFound
+	}
+	return copyRecipe(recipe), nil
+}
+
+// Search ranks recipes with the embedded inverted index.
+func (repository *MemoryRecipeRepository) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	hits := repository.index.Search(query)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, SearchResult{Recipe: copyRecipe(repository.recipes[hit.ID]), Score: hit.Score})
+	}
+	return results, nil
+}
+
+func (repository *MemoryRecipeRepository) Create(ctx context.Context, recipe models.Recipe) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	repository.recipes[recipe.ID] = copyRecipe(recipe)
+	repository.index.Add(recipe)
+	return nil
+}
+
+func (repository *MemoryRecipeRepository) Update(ctx context.Context, id primitive.ObjectID, recipe models.Recipe) (models.Recipe, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	current, ok := repository.recipes[id]
+	if !ok || current.DeletedAt != nil {
+		return models.Recipe{}, ErrRecipeNotFound
+	}
+	if current.Version != recipe.Version {
+		return models.Recipe{}, &VersionConflictError{Current: current.Version}
+	}
+	current.Version++
+	current.Name = recipe.Name
+	current.Instructions = copyStrings(recipe.Instructions)
+	current.Ingredients = copyStrings(recipe.Ingredients)
+	current.Tags = copyStrings(recipe.Tags)
+	current.UpdatedAt = recipe.UpdatedAt
+	repository.recipes[id] = current
+	repository.index.Add(current)
+	return copyRecipe(current), nil
+}
+
+func (repository *MemoryRecipeRepository) Delete(ctx context.Context, id primitive.ObjectID, version int64, deletedBy string) (models.Recipe, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	current, ok := repository.recipes[id]
+	if !ok || current.DeletedAt != nil {
+		return models.Recipe{}, ErrRecipeNotFound
+	}
+	if current.Version != version {
+		return models.Recipe{}, &VersionConflictError{Current: current.Version}
+	}
+	now := time.Now()
+	current.DeletedAt = &now
+	current.DeletedBy = deletedBy
+	current.Version++
+	repository.recipes[id] = current
+	repository.index.Remove(id)
+	return copyRecipe(current), nil
+}
+
+func (repository *MemoryRecipeRepository) Restore(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	current, ok := repository.recipes[id]
+	if !ok || current.DeletedAt == nil {
+		return models.Recipe{}, ErrRecipeNotFound
+	}
+	current.DeletedAt = nil
+	current.DeletedBy = ""
+	current.UpdatedAt = time.Now()
+	current.Version++
+	repository.recipes[id] = current
+	repository.index.Add(current)
+	return copyRecipe(current), nil
+}
+
+func (repository *MemoryRecipeRepository) Purge(ctx context.Context, before time.Time) ([]primitive.ObjectID, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	var purged []primitive.ObjectID
+	for id, recipe := range repository.recipes {
+		if recipe.DeletedAt != nil && recipe.DeletedAt.Before(before) {
+			delete(repository.recipes, id)
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
+}
+
+// copyRecipe returns a deep copy so callers cannot mutate stored slices.
+func copyRecipe(recipe models.Recipe) models.Recipe {
+	recipe.Tags = copyStrings(recipe.Tags)
+	recipe.Ingredients = copyStrings(recipe.Ingredients)
+	recipe.Instructions = copyStrings(recipe.Instructions)
+	if recipe.DeletedAt != nil {
+		deletedAt := *recipe.DeletedAt
+		recipe.DeletedAt = &deletedAt
+	}
+	return recipe
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string(nil), values...)
+}
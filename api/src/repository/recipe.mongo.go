@@ -0,0 +1,348 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"microservice/src/search"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoRecipeRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRecipeRepository(collection *mongo.Collection) *MongoRecipeRepository {
+	return &MongoRecipeRepository{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the indexes backing the list filters and sort orders.
+func (repository *MongoRecipeRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repository.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "publishedAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}, {Key: "publishedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "tags", Value: "text"},
+				{Key: "ingredients", Value: "text"},
+				{Key: "instructions", Value: "text"},
+			},
+			Options: options.Index().SetName("recipes_text").SetWeights(search.FieldWeights),
+		},
+	})
+	return err
+}
+
+func (repository *MongoRecipeRepository) List(ctx context.Context, listOptions ListOptions) (RecipePage, error) {
+	listOptions, cur, err := repository.find(ctx, listOptions)
+	if err != nil {
+		return RecipePage{}, err
+	}
+	defer cur.Close(ctx)
+
+	recipes := make([]models.Recipe, 0)
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			return RecipePage{}, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	if err := cur.Err(); err != nil {
+		return RecipePage{}, err
+	}
+	return newRecipePage(recipes, listOptions), nil
+}
+
+// WriteJSON encodes each document as it is read from the cursor instead of
+// collecting the page first.
+func (repository *MongoRecipeRepository) WriteJSON(ctx context.Context, listOptions ListOptions, w PageWriter) error {
+	listOptions, cur, err := repository.find(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	return encodeRecipeList(w, listOptions, func(recipe *models.Recipe) (bool, error) {
+		if !cur.Next(ctx) {
+			return false, cur.Err()
+		}
+		return true, cur.Decode(recipe)
+	})
+}
+
+// find opens a cursor over one page plus one extra document, which tells
+// whether another page follows.
+func (repository *MongoRecipeRepository) find(ctx context.Context, listOptions ListOptions) (ListOptions, *mongo.Cursor, error) {
+	listOptions, err := listOptions.normalize()
+	if err != nil {
+		return listOptions, nil, err
+	}
+
+	filter := mongoFilter(listOptions.Query)
+	if listOptions.After != nil {
+		filter = bson.M{"$and": bson.A{filter, mongoCursorFilter(listOptions.After, listOptions.Query)}}
+	}
+
+	direction := -1
+	if listOptions.Query.Ascending {
+		direction = 1
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: listOptions.Query.SortBy, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(listOptions.Limit + 1))
+
+	cur, err := repository.collection.Find(ctx, filter, findOptions)
+	return listOptions, cur, err
+}
+
+// mongoFilter translates the filter part of a normalized query.
+func mongoFilter(query RecipeQuery) bson.M {
+	filter := bson.M{"deletedAt": bson.M{"$exists": query.Deleted}}
+	if len(query.Tags) > 0 {
+		if query.MatchAllTags {
+			filter["tags"] = bson.M{"$all": query.Tags}
+		} else {
+			filter["tags"] = bson.M{"$in": query.Tags}
+		}
+	}
+	if query.Ingredient != "" {
+		filter["ingredients"] = primitive.Regex{Pattern: regexp.QuoteMeta(query.Ingredient), Options: "i"}
+	}
+	if query.NamePrefix != "" {
+		// A case sensitive anchored regex can use the name index.
+		filter["name"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.NamePrefix)}
+	}
+	publishedAt := bson.M{}
+	if !query.PublishedAfter.IsZero() {
+		publishedAt["$gte"] = query.PublishedAfter
+	}
+	if !query.PublishedBefore.IsZero() {
+		publishedAt["$lt"] = query.PublishedBefore
+	}
+	if len(publishedAt) > 0 {
+		filter["publishedAt"] = publishedAt
+	}
+	return filter
+}
+
+// mongoCursorFilter selects the documents sorted after the cursor.
+func mongoCursorFilter(cursor *Cursor, query RecipeQuery) bson.M {
+	operator := "$lt"
+	if query.Ascending {
+		operator = "$gt"
+	}
+	var value interface{} = cursor.PublishedAt
+	if query.SortBy == SortByName {
+		value = cursor.Name
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{query.SortBy: bson.M{operator: value}},
+		bson.M{query.SortBy: value, "_id": bson.M{operator: cursor.ID}},
+	}}
+}
+
+func (repository *MongoRecipeRepository) Get(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	var recipe models.Recipe
+	err := repository.collection.FindOne(ctx, liveFilter(id)).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, ErrRecipeNotFound
+	}
+	return recipe, err
+}
+
+func (repository *MongoRecipeRepository) GetDeleted(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	var recipe models.Recipe
+	err := repository.collection.FindOne(ctx, bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}}).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, ErrRecipeNotFound
+	}
+	return recipe, err
+}
+
+// Search ranks recipes with the collection text index.
+func (repository *MongoRecipeRepository) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.M{"score": score}).
+		SetLimit(int64(limit))
+
+	filter := bson.M{"$text": bson.M{"$search": query}, "deletedAt": bson.M{"$exists": false}}
+	cur, err := repository.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]SearchResult, 0)
+	for cur.Next(ctx) {
+		var document struct {
+			models.Recipe `bson:",inline"`
+			Score         float64 `bson:"score"`
+		}
+		if err := cur.Decode(&document); err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Recipe: document.Recipe, Score: document.Score})
+	}
+	return results, cur.Err()
+}
+
+func (repository *MongoRecipeRepository) Create(ctx context.Context, recipe models.Recipe) error {
+	_, err := repository.collection.InsertOne(ctx, recipe)
+	return err
+}
+
+func (repository *MongoRecipeRepository) Update(ctx context.Context, id primitive.ObjectID, recipe models.Recipe) (models.Recipe, error) {
+	return repository.findOneAndUpdate(ctx, id, versionFilter(id, recipe.Version), bson.M{
+		"$set": bson.M{
+			"name":         recipe.Name,
+			"instructions": recipe.Instructions,
+			"ingredients":  recipe.Ingredients,
+			"tags":         recipe.Tags,
+			"updatedAt":    recipe.UpdatedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+}
+
+func (repository *MongoRecipeRepository) Delete(ctx context.Context, id primitive.ObjectID, version int64, deletedBy string) (models.Recipe, error) {
+	return repository.findOneAndUpdate(ctx, id, versionFilter(id, version), bson.M{
+		"$set": bson.M{"deletedAt": time.Now(), "deletedBy": deletedBy},
+		"$inc": bson.M{"version": 1},
+	})
+}
+
+func (repository *MongoRecipeRepository) Restore(ctx context.Context, id primitive.ObjectID) (models.Recipe, error) {
+	var recipe models.Recipe
+	err := repository.collection.FindOneAndUpdate(ctx, bson.M{
+		"_id":       id,
+		"deletedAt": bson.M{"$exists": true},
+	}, bson.M{
+		"$unset": bson.M{"deletedAt": "", "deletedBy": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$inc":   bson.M{"version": 1},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, ErrRecipeNotFound
+	}
+	return recipe, err
+}
+
+// findOneAndUpdate applies a conditional write and returns the updated
+// recipe, or explains why the filter matched nothing.
+func (repository *MongoRecipeRepository) findOneAndUpdate(ctx context.Context, id primitive.ObjectID, filter, update bson.M) (models.Recipe, error) {
+	var recipe models.Recipe
+	err := repository.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, repository.conflict(ctx, id)
+	}
+	return recipe, err
+}
+
+func (repository *MongoRecipeRepository) Purge(ctx context.Context, before time.Time) ([]primitive.ObjectID, error) {
+	expired := bson.M{"deletedAt": bson.M{"$lt": before}}
+	ids, err := repository.ids(ctx, expired)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	expired["_id"] = bson.M{"$in": ids}
+	result, err := repository.collection.DeleteMany(ctx, expired)
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == int64(len(ids)) {
+		return ids, nil
+	}
+
+	// Some were restored in the meantime: only report those that are gone.
+	kept, err := repository.ids(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	return withoutIDs(ids, kept), nil
+}
+
+// ids returns the IDs of the recipes matching filter.
+func (repository *MongoRecipeRepository) ids(ctx context.Context, filter bson.M) ([]primitive.ObjectID, error) {
+	cur, err := repository.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var documents []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(documents))
+	for _, document := range documents {
+		ids = append(ids, document.ID)
+	}
+	return ids, nil
+}
+
+// withoutIDs returns the IDs in ids that are not in excluded.
+func withoutIDs(ids, excluded []primitive.ObjectID) []primitive.ObjectID {
+	skip := make(map[primitive.ObjectID]bool, len(excluded))
+	for _, id := range excluded {
+		skip[id] = true
+	}
+	kept := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !skip[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// liveFilter matches a recipe that is not in the trash.
+func liveFilter(id primitive.ObjectID) bson.M {
+	return bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}
+}
+
+// versionFilter matches a live recipe at the given version. Recipes stored
+// before versioning have no version field and count as version 0.
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	filter := liveFilter(id)
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	} else {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// conflict explains why a conditional write matched nothing: either the
+// recipe is gone or in the trash, or it is at another version.
+func (repository *MongoRecipeRepository) conflict(ctx context.Context, id primitive.ObjectID) error {
+	var current struct {
+		Version int64 `bson:"version"`
+	}
+	findOptions := options.FindOne().SetProjection(bson.M{"version": 1})
+	err := repository.collection.FindOne(ctx, liveFilter(id), findOptions).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return ErrRecipeNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return &VersionConflictError{Current: current.Version}
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microservice/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrRecipeNotFound is returned when no recipe matches the requested ID.
+var ErrRecipeNotFound = errors.New("recipe not found")
+
+// VersionConflictError is returned by Update and Delete when the stored
+// recipe is no longer at the version the caller expected.
+type VersionConflictError struct {
+	Current int64
+}
+
+func (err *VersionConflictError) Error() string {
+	return fmt.Sprintf("recipe has been modified, current version is %d", err.Current)
+}
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ListOptions selects a page of the recipes matching Query.
+type ListOptions struct {
+	Query RecipeQuery
+	Limit int
+	After *Cursor
+}
+
+// normalize canonicalizes the query and rejects cursors issued for another
+// sort order.
+func (options ListOptions) normalize() (ListOptions, error) {
+	options.Query = options.Query.Normalize()
+	if options.After != nil && options.After.Sort != options.Query.Sort() {
+		return options, ErrInvalidCursor
+	}
+	return options, nil
+}
+
+// RecipePage holds one page of recipes. Next is nil on the last page.
+type RecipePage struct {
+	Recipes []models.Recipe
+	Next    *Cursor
+}
+
+// SearchResult is a recipe matching a full-text search and its relevance.
+type SearchResult struct {
+	Recipe models.Recipe
+	Score  float64
+}
+
+// RecipeRepository abstracts the storage used by RecipesController so the
+// handlers can run against MongoDB or a purely in-memory store.
+type RecipeRepository interface {
+	List(ctx context.Context, options ListOptions) (RecipePage, error)
+	// WriteJSON sends the same page as List to w, encoded as the response
+	// body. Once it has written to w, an error can only be logged.
+	WriteJSON(ctx context.Context, options ListOptions, w PageWriter) error
+	Get(ctx context.Context, id primitive.ObjectID) (models.Recipe, error)
+	// GetDeleted returns a recipe only if it is in the trash.
+	GetDeleted(ctx context.Context, id primitive.ObjectID) (models.Recipe, error)
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+	Create(ctx context.Context, recipe models.Recipe) error
+	// Update applies only if the stored recipe is at recipe.Version, and
+	// increments the stored version. Update, Delete and Restore return the
+	// recipe as stored by the write.
+	Update(ctx context.Context, id primitive.ObjectID, recipe models.Recipe) (models.Recipe, error)
+	// Delete moves a recipe to the trash if it is at version. Recipes in
+	// the trash are hidden from every other method until restored.
+	Delete(ctx context.Context, id primitive.ObjectID, version int64, deletedBy string) (models.Recipe, error)
+	// Restore takes a recipe out of the trash.
+	Restore(ctx context.Context, id primitive.ObjectID) (models.Recipe, error)
+	// Purge permanently removes recipes deleted before the given time and
+	// returns their IDs.
+	Purge(ctx context.Context, before time.Time) ([]primitive.ObjectID, error)
+}
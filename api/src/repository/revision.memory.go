@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRevisionRepository keeps revisions in process memory, next to
+// MemoryRecipeRepository.
+type MemoryRevisionRepository struct {
+	mu        sync.RWMutex
+	revisions map[primitive.ObjectID][]models.Revision
+}
+
+func NewMemoryRevisionRepository() *MemoryRevisionRepository {
+	return &MemoryRevisionRepository{
+		revisions: make(map[primitive.ObjectID][]models.Revision),
+	}
+}
+
+func (repository *MemoryRevisionRepository) Add(ctx context.Context, revision models.Revision) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	snapshot := copyRecipe(*revision.Recipe)
+	revision.Recipe = &snapshot
+	repository.revisions[revision.RecipeID] = append(repository.revisions[revision.RecipeID], revision)
+	return nil
+}
+
+func (repository *MemoryRevisionRepository) DeleteRecipes(ctx context.Context, recipeIDs []primitive.ObjectID) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for _, id := range recipeIDs {
+		delete(repository.revisions, id)
+	}
+	return nil
+}
+
+func (repository *MemoryRevisionRepository) List(ctx context.Context, recipeID primitive.ObjectID) ([]models.Revision, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	stored := repository.revisions[recipeID]
+	revisions := make([]models.Revision, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		revision := stored[i]
+		revision.Recipe = nil
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+func (repository *MemoryRevisionRepository) Get(ctx context.Context, recipeID primitive.ObjectID, number int64) (models.Revision, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	for _, revision := range repository.revisions[recipeID] {
+		if revision.Number == number {
+			snapshot := copyRecipe(*revision.Recipe)
+			revision.Recipe = &snapshot
+			return revision, nil
+		}
+	}
+	return models.Revision{}, ErrRevisionNotFound
+}
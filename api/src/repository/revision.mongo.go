@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoRevisionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRevisionRepository(collection *mongo.Collection) *MongoRevisionRepository {
+	return &MongoRevisionRepository{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes makes revision numbers unique per recipe, so two writers
+// can never record the same revision.
+func (repository *MongoRevisionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repository.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "recipeId", Value: 1}, {Key: "revision", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (repository *MongoRevisionRepository) Add(ctx context.Context, revision models.Revision) error {
+	_, err := repository.collection.InsertOne(ctx, revision)
+	return err
+}
+
+func (repository *MongoRevisionRepository) DeleteRecipes(ctx context.Context, recipeIDs []primitive.ObjectID) error {
+	_, err := repository.collection.DeleteMany(ctx, bson.M{"recipeId": bson.M{"$in": recipeIDs}})
+	return err
+}
+
+func (repository *MongoRevisionRepository) List(ctx context.Context, recipeID primitive.ObjectID) ([]models.Revision, error) {
+	findOptions := options.Find().
+		SetProjection(bson.M{"recipe": 0}).
+		SetSort(bson.M{"revision": -1})
+
+	cur, err := repository.collection.Find(ctx, bson.M{"recipeId": recipeID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	revisions := make([]models.Revision, 0)
+	if err := cur.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (repository *MongoRevisionRepository) Get(ctx context.Context, recipeID primitive.ObjectID, number int64) (models.Revision, error) {
+	var revision models.Revision
+	err := repository.collection.FindOne(ctx, bson.M{"recipeId": recipeID, "revision": number}).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return revision, ErrRevisionNotFound
+	}
+	return revision, err
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microservice/src/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrRevisionNotFound is returned when a recipe has no such revision.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// RevisionRepository stores the revision history of recipes. Revisions are
+// only ever added, and removed along with their recipe when it is purged.
+type RevisionRepository interface {
+	Add(ctx context.Context, revision models.Revision) error
+	// DeleteRecipes removes the whole history of the given recipes.
+	DeleteRecipes(ctx context.Context, recipeIDs []primitive.ObjectID) error
+	// List returns the revisions of a recipe, newest first, without their
+	// snapshots.
+	List(ctx context.Context, recipeID primitive.ObjectID) ([]models.Revision, error)
+	Get(ctx context.Context, recipeID primitive.ObjectID, number int64) (models.Revision, error)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository keeps users in process memory, for tests and for
+// running the API without MongoDB.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+func NewMemoryUserRepository(users ...models.User) *MemoryUserRepository {
+	repository := &MemoryUserRepository{
+		users: make(map[string]models.User, len(users)),
+	}
+	for _, user := range users {
+		repository.users[user.Username] = user
+	}
+	return repository
+}
+
+func (repository *MemoryUserRepository) FindByUsername(ctx context.Context, username string) (models.User, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	user, ok := repository.users[username]
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (repository *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return repository.find(func(user models.User) bool {
+		return user.Email == email
+	})
+}
+
+func (repository *MemoryUserRepository) FindByIdentity(ctx context.Context, identity models.ExternalIdentity) (models.User, error) {
+	return repository.find(func(user models.User) bool {
+		return user.OIDC != nil && *user.OIDC == identity
+	})
+}
+
+func (repository *MemoryUserRepository) find(matches func(models.User) bool) (models.User, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	for _, user := range repository.users {
+		if matches(user) {
+			return copyUser(user), nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+func (repository *MemoryUserRepository) Create(ctx context.Context, user models.User) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for _, existing := range repository.users {
+		if existing.Username == user.Username || (user.Email != "" && existing.Email == user.Email) ||
+			(user.OIDC != nil && existing.OIDC != nil && *existing.OIDC == *user.OIDC) {
+			return ErrUserExists
+		}
+	}
+	repository.users[user.Username] = copyUser(user)
+	return nil
+}
+
+func (repository *MemoryUserRepository) LinkIdentity(ctx context.Context, username string, identity models.ExternalIdentity) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	user, ok := repository.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for _, existing := range repository.users {
+		if existing.OIDC != nil && *existing.OIDC == identity {
+			return ErrUserExists
+		}
+	}
+	user.OIDC = &identity
+	repository.users[username] = user
+	return nil
+}
+
+func (repository *MemoryUserRepository) UpdatePassword(ctx context.Context, username, hash string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	user, ok := repository.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Password = hash
+	repository.users[username] = user
+	return nil
+}
+
+func (repository *MemoryUserRepository) SetVerification(ctx context.Context, email string, verification models.EmailVerification, replaceBefore time.Time) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for username, user := range repository.users {
+		if user.Email != email || user.EmailVerified {
+			continue
+		}
+		if user.Verification == nil || user.Verification.ExpiresAt.Before(replaceBefore) {
+			user.Verification = &verification
+			repository.users[username] = user
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (repository *MemoryUserRepository) VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (models.User, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for username, user := range repository.users {
+		if user.Verification == nil || user.Verification.TokenHash != tokenHash || !user.Verification.ExpiresAt.After(now) {
+			continue
+		}
+		user.EmailVerified = true
+		user.Verification = nil
+		repository.users[username] = user
+		return user, nil
+	}
+	return models.User{}, ErrVerificationNotFound
+}
+
+// copyUser keeps callers from sharing the stored pointers.
+func copyUser(user models.User) models.User {
+	if user.Verification != nil {
+		verification := *user.Verification
+		user.Verification = &verification
+	}
+	if user.OIDC != nil {
+		identity := *user.OIDC
+		user.OIDC = &identity
+	}
+	return user
+}
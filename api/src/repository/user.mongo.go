@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"microservice/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoUserRepository(collection *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes makes usernames, emails and external identities unique.
+// Users created before sign up have no email, hence the sparse indexes.
+func (repository *MongoUserRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repository.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "verification.tokenHash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys:    bson.D{{Key: "oidc.issuer", Value: 1}, {Key: "oidc.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	})
+	return err
+}
+
+func (repository *MongoUserRepository) FindByUsername(ctx context.Context, username string) (models.User, error) {
+	return repository.findOne(ctx, bson.M{"username": username})
+}
+
+func (repository *MongoUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return repository.findOne(ctx, bson.M{"email": email})
+}
+
+func (repository *MongoUserRepository) FindByIdentity(ctx context.Context, identity models.ExternalIdentity) (models.User, error) {
+	return repository.findOne(ctx, bson.M{"oidc.issuer": identity.Issuer, "oidc.subject": identity.Subject})
+}
+
+func (repository *MongoUserRepository) findOne(ctx context.Context, filter bson.M) (models.User, error) {
+	var user models.User
+	err := repository.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (repository *MongoUserRepository) Create(ctx context.Context, user models.User) error {
+	_, err := repository.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (repository *MongoUserRepository) UpdatePassword(ctx context.Context, username, hash string) error {
+	return repository.updateOne(ctx, bson.M{"username": username}, bson.M{
+		"$set": bson.M{"password": hash},
+	})
+}
+
+func (repository *MongoUserRepository) LinkIdentity(ctx context.Context, username string, identity models.ExternalIdentity) error {
+	err := repository.updateOne(ctx, bson.M{"username": username}, bson.M{
+		"$set": bson.M{"oidc": identity},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (repository *MongoUserRepository) SetVerification(ctx context.Context, email string, verification models.EmailVerification, replaceBefore time.Time) error {
+	return repository.updateOne(ctx, bson.M{
+		"email":         email,
+		"emailVerified": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"verification": bson.M{"$exists": false}},
+			bson.M{"verification.expiresAt": bson.M{"$lt": replaceBefore}},
+		},
+	}, bson.M{
+		"$set": bson.M{"verification": verification},
+	})
+}
+
+func (repository *MongoUserRepository) VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (models.User, error) {
+	var user models.User
+	err := repository.collection.FindOneAndUpdate(ctx, bson.M{
+		"verification.tokenHash": tokenHash,
+		"verification.expiresAt": bson.M{"$gt": now},
+	}, bson.M{
+		"$set":   bson.M{"emailVerified": true},
+		"$unset": bson.M{"verification": ""},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrVerificationNotFound
+	}
+	return user, err
+}
+
+func (repository *MongoUserRepository) updateOne(ctx context.Context, filter, update bson.M) error {
+	result, err := repository.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
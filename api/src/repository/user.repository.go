@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microservice/src/models"
+	"time"
+)
+
+var (
+	// ErrUserNotFound is returned when no user has the requested username.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned by Create when the username or email is
+	// already taken, or the external identity already linked.
+	ErrUserExists = errors.New("username or email is already registered")
+	// ErrVerificationNotFound is returned by VerifyEmail for unknown and
+	// expired tokens.
+	ErrVerificationNotFound = errors.New("verification token is invalid or has expired")
+)
+
+// UserRepository stores the accounts AuthController signs in. Passwords are
+// stored as hashes from the password package.
+type UserRepository interface {
+	FindByUsername(ctx context.Context, username string) (models.User, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	// FindByIdentity returns the user linked to an external identity.
+	FindByIdentity(ctx context.Context, identity models.ExternalIdentity) (models.User, error)
+	// Create fails with ErrUserExists if the username, email or external
+	// identity is taken.
+	Create(ctx context.Context, user models.User) error
+	// LinkIdentity links a user to an external identity.
+	LinkIdentity(ctx context.Context, username string, identity models.ExternalIdentity) error
+	// UpdatePassword replaces the stored password hash of a user.
+	UpdatePassword(ctx context.Context, username, hash string) error
+	// SetVerification replaces the pending verification of the unverified
+	// user with that email, returning ErrUserNotFound if there is none. A
+	// pending verification expiring at or after replaceBefore is kept, and
+	// ErrUserNotFound returned too, so recent links are not replaced.
+	SetVerification(ctx context.Context, email string, verification models.EmailVerification, replaceBefore time.Time) error
+	// VerifyEmail marks the email of the user holding an unexpired token
+	// with that hash as verified, and discards the token.
+	VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (models.User, error)
+}
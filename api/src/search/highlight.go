@@ -0,0 +1,75 @@
+package search
+
+import (
+	"html"
+	"microservice/src/models"
+	"strings"
+)
+
+const (
+	// snippetRadius is the number of words kept on each side of the first
+	// match when a value is too long to be returned whole.
+	snippetRadius = 8
+	// maxSnippets caps the snippets returned per field.
+	maxSnippets = 3
+)
+
+// Highlight returns, per field, snippets of the recipe text matching the
+// query. Matched words are wrapped in <em> tags and the rest of the text is
+// HTML escaped.
+func Highlight(recipe models.Recipe, query string) map[string][]string {
+	terms := make(map[string]bool)
+	for _, term := range uniqueTerms(query) {
+		terms[term] = true
+	}
+
+	highlights := make(map[string][]string)
+	for field, values := range Fields(recipe) {
+		for _, value := range values {
+			if snippet, ok := highlightValue(value, terms); ok {
+				highlights[field] = append(highlights[field], snippet)
+				if len(highlights[field]) == maxSnippets {
+					break
+				}
+			}
+		}
+	}
+	return highlights
+}
+
+func highlightValue(value string, terms map[string]bool) (string, bool) {
+	words := strings.Fields(value)
+	first := -1
+	marked := make([]string, len(words))
+	for i, word := range words {
+		marked[i] = html.EscapeString(word)
+		for _, term := range Tokenize(word) {
+			if terms[term] {
+				marked[i] = "<em>" + marked[i] + "</em>"
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	start, end := 0, len(words)
+	if first-snippetRadius > 0 {
+		start = first - snippetRadius
+	}
+	if first+snippetRadius+1 < end {
+		end = first + snippetRadius + 1
+	}
+	snippet := strings.Join(marked[start:end], " ")
+	if start > 0 {
+		snippet = "… " + snippet
+	}
+	if end < len(words) {
+		snippet += " …"
+	}
+	return snippet, true
+}
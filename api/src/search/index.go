@@ -0,0 +1,141 @@
+package search
+
+import (
+	"bytes"
+	"math"
+	"microservice/src/models"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FieldWeights ranks a match in the recipe name above one in its tags,
+// ingredients or instructions. The MongoDB text index uses the same weights.
+var FieldWeights = map[string]int{
+	"name":         10,
+	"tags":         5,
+	"ingredients":  2,
+	"instructions": 1,
+}
+
+// Hit is a recipe matching a search along with its relevance score.
+type Hit struct {
+	ID    primitive.ObjectID
+	Score float64
+}
+
+// Index is an in-memory inverted index over recipes. It is safe for
+// concurrent use.
+type Index struct {
+	mu sync.RWMutex
+	// postings maps a term to the weighted frequency of the term per recipe.
+	postings map[string]map[primitive.ObjectID]float64
+	// terms lists the terms indexed for a recipe so it can be removed.
+	terms map[primitive.ObjectID][]string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[primitive.ObjectID]float64),
+		terms:    make(map[primitive.ObjectID][]string),
+	}
+}
+
+// Add indexes a recipe, replacing any previous version of it.
+func (index *Index) Add(recipe models.Recipe) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(recipe.ID)
+	frequencies := make(map[string]float64)
+	for field, values := range Fields(recipe) {
+		for _, value := range values {
+			for _, term := range Tokenize(value) {
+				frequencies[term] += float64(FieldWeights[field])
+			}
+		}
+	}
+
+	terms := make([]string, 0, len(frequencies))
+	for term, frequency := range frequencies {
+		if index.postings[term] == nil {
+			index.postings[term] = make(map[primitive.ObjectID]float64)
+		}
+		index.postings[term][recipe.ID] = frequency
+		terms = append(terms, term)
+	}
+	index.terms[recipe.ID] = terms
+}
+
+// Remove drops a recipe from the index.
+func (index *Index) Remove(id primitive.ObjectID) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.remove(id)
+}
+
+func (index *Index) remove(id primitive.ObjectID) {
+	for _, term := range index.terms[id] {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	delete(index.terms, id)
+}
+
+// Search returns the recipes matching any term of the query, most relevant
+// first. Scores are the sum of weighted term frequencies scaled by inverse
+// document frequency.
+func (index *Index) Search(query string) []Hit {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	total := float64(len(index.terms))
+	scores := make(map[primitive.ObjectID]float64)
+	for _, term := range uniqueTerms(query) {
+		documents := index.postings[term]
+		if len(documents) == 0 {
+			continue
+		}
+		idf := math.Log(1 + total/float64(len(documents)))
+		for id, frequency := range documents {
+			scores[id] += frequency * idf
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return bytes.Compare(hits[i].ID[:], hits[j].ID[:]) > 0
+	})
+	return hits
+}
+
+// Fields returns the searchable text of a recipe keyed by JSON field name.
+func Fields(recipe models.Recipe) map[string][]string {
+	return map[string][]string{
+		"name":         {recipe.Name},
+		"tags":         recipe.Tags,
+		"ingredients":  recipe.Ingredients,
+		"instructions": recipe.Instructions,
+	}
+}
+
+func uniqueTerms(query string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, term := range Tokenize(query) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
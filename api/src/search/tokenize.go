@@ -0,0 +1,49 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "into": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+	"then": true, "to": true, "with": true,
+}
+
+// Tokenize splits text into lower case, stemmed terms with stop words removed.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	return terms
+}
+
+// stem strips a few common English suffixes so that "tomatoes" matches
+// "tomato" and "baking" matches "bake". It is deliberately crude.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-3] + "y"
+	case len(word) > 4 && strings.HasSuffix(word, "oes"):
+		word = word[:len(word)-2]
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		word = word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		word = word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		word = word[:len(word)-1]
+	}
+	if len(word) > 3 {
+		word = strings.TrimSuffix(word, "e")
+	}
+	return word
+}
@@ -0,0 +1,50 @@
+// Package session keeps track of signed in users: the refresh tokens of
+// each session, and the access tokens revoked before they expire.
+//
+// A session starts at sign in with one refresh token. Every refresh
+// consumes the token and hands out its successor, so a refresh token is
+// only ever used once. Presenting a used token means it leaked: the whole
+// session, and with it every refresh token and access token issued to it,
+// is revoked.
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for unknown and expired refresh tokens, and
+	// tokens of revoked sessions.
+	ErrNotFound = errors.New("refresh token is invalid or has expired")
+	// ErrReused is returned when a refresh token is presented again. The
+	// session has been revoked.
+	ErrReused = errors.New("refresh token has already been used, sign in again")
+)
+
+// Session is a sign in and the chain of refresh tokens rotated from it.
+type Session struct {
+	ID       string
+	Username string
+}
+
+// Store keeps sessions and refresh tokens, which are passed in hashed,
+// along with the access token deny list. Sessions expire ttl after their
+// last refresh.
+type Store interface {
+	// Start opens a session whose first refresh token has tokenHash.
+	Start(ctx context.Context, session Session, tokenHash string, ttl time.Duration) error
+	// Rotate consumes the refresh token with oldHash and replaces it with
+	// newHash in the same session. A token consumed before yields ErrReused
+	// along with the session it belonged to.
+	Rotate(ctx context.Context, oldHash, newHash string, ttl time.Duration) (Session, error)
+	// Revoke ends a session, invalidating its refresh tokens and the
+	// access tokens issued with its ID.
+	Revoke(ctx context.Context, id string) error
+	// Deny revokes a single access token, by ID, until it expires.
+	Deny(ctx context.Context, tokenID string, expiresAt time.Time) error
+	// Revoked reports whether an access token has been denied or its
+	// session revoked. Either ID may be empty.
+	Revoked(ctx context.Context, tokenID, sessionID string) (bool, error)
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreRotation(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	session := Session{ID: "s1", Username: "packt"}
+	assert.Nil(t, store.Start(ctx, session, "first", time.Hour))
+
+	rotated, err := store.Rotate(ctx, "first", "second", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, session, rotated)
+
+	rotated, err = store.Rotate(ctx, "second", "third", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, session, rotated)
+
+	revoked, err := store.Revoked(ctx, "", "s1")
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+
+	_, err = store.Rotate(ctx, "unknown", "fourth", time.Hour)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStoreReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.Start(ctx, Session{ID: "s1", Username: "packt"}, "first", time.Hour)
+	store.Rotate(ctx, "first", "second", time.Hour)
+
+	reused, err := store.Rotate(ctx, "first", "stolen", time.Hour)
+	assert.Equal(t, ErrReused, err)
+	assert.Equal(t, "packt", reused.Username)
+
+	// The legitimate holder is signed out too.
+	_, err = store.Rotate(ctx, "second", "third", time.Hour)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.Rotate(ctx, "stolen", "fifth", time.Hour)
+	assert.Equal(t, ErrNotFound, err)
+
+	revoked, _ := store.Revoked(ctx, "", "s1")
+	assert.True(t, revoked)
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	store.Start(ctx, Session{ID: "s1", Username: "packt"}, "first", time.Hour)
+	now = now.Add(59 * time.Minute)
+	_, err := store.Rotate(ctx, "first", "second", time.Hour)
+	assert.Nil(t, err)
+
+	// Refreshing extends the session.
+	now = now.Add(59 * time.Minute)
+	revoked, _ := store.Revoked(ctx, "", "s1")
+	assert.False(t, revoked)
+
+	now = now.Add(2 * time.Minute)
+	_, err = store.Rotate(ctx, "second", "third", time.Hour)
+	assert.Equal(t, ErrNotFound, err)
+	revoked, _ = store.Revoked(ctx, "", "s1")
+	assert.True(t, revoked)
+}
+
+func TestMemoryStoreDeny(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	store.Deny(ctx, "token", now.Add(time.Minute))
+	store.Deny(ctx, "expired", now.Add(-time.Minute))
+
+	revoked, _ := store.Revoked(ctx, "token", "")
+	assert.True(t, revoked)
+	revoked, _ = store.Revoked(ctx, "expired", "")
+	assert.False(t, revoked)
+	revoked, _ = store.Revoked(ctx, "", "")
+	assert.False(t, revoked)
+
+	now = now.Add(time.Minute)
+	revoked, _ = store.Revoked(ctx, "token", "")
+	assert.False(t, revoked)
+}
+
+func TestGuardedStore(t *testing.T) {
+	ctx := context.Background()
+	errOpen := errors.New("circuit open")
+	var failures int
+	open := false
+	guard := func(call func() error) error {
+		if open {
+			return errOpen
+		}
+		err := call()
+		if err != nil {
+			failures++
+		}
+		return err
+	}
+	store := NewGuardedStore(NewMemoryStore(), guard)
+
+	assert.Nil(t, store.Start(ctx, Session{ID: "s1", Username: "packt"}, "first", time.Hour))
+	_, err := store.Rotate(ctx, "unknown", "second", time.Hour)
+	assert.Equal(t, ErrNotFound, err)
+	// Unknown tokens are not an outage.
+	assert.Equal(t, 0, failures)
+
+	open = true
+	_, err = store.Revoked(ctx, "", "s1")
+	assert.Equal(t, errOpen, err)
+	_, err = store.Rotate(ctx, "first", "second", time.Hour)
+	assert.Equal(t, errOpen, err)
+
+	open = false
+	rotated, err := store.Rotate(ctx, "first", "second", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "packt", rotated.Username)
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// GuardedStore runs every call to store through guard, typically the
+// circuit breaker of the Redis server holding the sessions, so that calls
+// fail fast during an outage instead of waiting out the client timeouts.
+// ErrNotFound and ErrReused are answers, not failures, and are not reported
+// to guard.
+type GuardedStore struct {
+	store Store
+	guard func(call func() error) error
+}
+
+func NewGuardedStore(store Store, guard func(call func() error) error) *GuardedStore {
+	return &GuardedStore{
+		store: store,
+		guard: guard,
+	}
+}
+
+func (guarded *GuardedStore) Start(ctx context.Context, session Session, tokenHash string, ttl time.Duration) error {
+	return guarded.do(func() error {
+		return guarded.store.Start(ctx, session, tokenHash, ttl)
+	})
+}
+
+func (guarded *GuardedStore) Rotate(ctx context.Context, oldHash, newHash string, ttl time.Duration) (Session, error) {
+	var session Session
+	err := guarded.do(func() error {
+		var err error
+		session, err = guarded.store.Rotate(ctx, oldHash, newHash, ttl)
+		return err
+	})
+	return session, err
+}
+
+func (guarded *GuardedStore) Revoke(ctx context.Context, id string) error {
+	return guarded.do(func() error {
+		return guarded.store.Revoke(ctx, id)
+	})
+}
+
+func (guarded *GuardedStore) Deny(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return guarded.do(func() error {
+		return guarded.store.Deny(ctx, tokenID, expiresAt)
+	})
+}
+
+func (guarded *GuardedStore) Revoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	var revoked bool
+	err := guarded.do(func() error {
+		var err error
+		revoked, err = guarded.store.Revoked(ctx, tokenID, sessionID)
+		return err
+	})
+	return revoked, err
+}
+
+func (guarded *GuardedStore) do(call func() error) error {
+	var answer error
+	if err := guarded.guard(func() error {
+		answer = call()
+		if answer == ErrNotFound || answer == ErrReused {
+			return nil
+		}
+		return answer
+	}); err != nil {
+		return err
+	}
+	return answer
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryToken struct {
+	session   string
+	used      bool
+	expiresAt time.Time
+}
+
+type memorySession struct {
+	username  string
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in process memory, for tests and for running
+// the API without Redis. Expired entries are dropped when next read.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	tokens   map[string]memoryToken
+	denied   map[string]time.Time
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memorySession),
+		tokens:   make(map[string]memoryToken),
+		denied:   make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (store *MemoryStore) Start(ctx context.Context, session Session, tokenHash string, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	expiresAt := store.now().Add(ttl)
+	store.sessions[session.ID] = memorySession{username: session.Username, expiresAt: expiresAt}
+	store.tokens[tokenHash] = memoryToken{session: session.ID, expiresAt: expiresAt}
+	return nil
+}
+
+func (store *MemoryStore) Rotate(ctx context.Context, oldHash, newHash string, ttl time.Duration) (Session, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	token, ok := store.tokens[oldHash]
+	if !ok || !store.now().Before(token.expiresAt) {
+		delete(store.tokens, oldHash)
+		return Session{}, ErrNotFound
+	}
+	stored, ok := store.liveSession(token.session)
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	session := Session{ID: token.session, Username: stored.username}
+	if token.used {
+		delete(store.sessions, token.session)
+		return session, ErrReused
+	}
+
+	token.used = true
+	store.tokens[oldHash] = token
+	expiresAt := store.now().Add(ttl)
+	store.tokens[newHash] = memoryToken{session: session.ID, expiresAt: expiresAt}
+	stored.expiresAt = expiresAt
+	store.sessions[session.ID] = stored
+	return session, nil
+}
+
+func (store *MemoryStore) Revoke(ctx context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.sessions, id)
+	return nil
+}
+
+func (store *MemoryStore) Deny(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if expiresAt.After(store.now()) {
+		store.denied[tokenID] = expiresAt
+	}
+	return nil
+}
+
+func (store *MemoryStore) Revoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if tokenID != "" {
+		if expiresAt, ok := store.denied[tokenID]; ok {
+			if store.now().Before(expiresAt) {
+				return true, nil
+			}
+			delete(store.denied, tokenID)
+		}
+	}
+	if sessionID != "" {
+		if _, ok := store.liveSession(sessionID); !ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *MemoryStore) liveSession(id string) (memorySession, bool) {
+	session, ok := store.sessions[id]
+	if ok && !store.now().Before(session.expiresAt) {
+		delete(store.sessions, id)
+		return session, false
+	}
+	return session, ok
+}
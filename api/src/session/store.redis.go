@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	sessionPrefix = "session:"
+	refreshPrefix = "refresh:"
+	deniedPrefix  = "denied:"
+)
+
+// rotateScript consumes the refresh token KEYS[1] and stores KEYS[2] in
+// its place, atomically so that two refreshes racing with the same token
+// cannot both succeed. Used tokens are kept until they expire, so reuse is
+// noticed.
+var rotateScript = redis.NewScript(`
+local session = redis.call('HGET', KEYS[1], 'session')
+if not session then
+	return {'missing'}
+end
+local sessionKey = '` + sessionPrefix + `' .. session
+local username = redis.call('GET', sessionKey)
+if not username then
+	return {'missing'}
+end
+if redis.call('HGET', KEYS[1], 'used') == '1' then
+	redis.call('DEL', sessionKey)
+	return {'reused', session, username}
+end
+redis.call('HSET', KEYS[1], 'used', '1')
+redis.call('HMSET', KEYS[2], 'session', session, 'used', '0')
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+redis.call('PEXPIRE', sessionKey, ARGV[1])
+return {'ok', session, username}
+`)
+
+// RedisStore shares sessions between replicas. A session is the key
+// session:<id> holding the username, its refresh tokens are hashes named
+// refresh:<hash>, and denied access tokens are keys denied:<id>.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (store *RedisStore) Start(ctx context.Context, session Session, tokenHash string, ttl time.Duration) error {
+	client := store.client.WithContext(ctx)
+	_, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(sessionPrefix+session.ID, session.Username, ttl)
+		pipe.HMSet(refreshPrefix+tokenHash, map[string]interface{}{"session": session.ID, "used": "0"})
+		pipe.PExpire(refreshPrefix+tokenHash, ttl)
+		return nil
+	})
+	return err
+}
+
+func (store *RedisStore) Rotate(ctx context.Context, oldHash, newHash string, ttl time.Duration) (Session, error) {
+	result, err := rotateScript.Run(store.client.WithContext(ctx),
+		[]string{refreshPrefix + oldHash, refreshPrefix + newHash},
+		int64(ttl/time.Millisecond)).Result()
+	if err != nil {
+		return Session{}, err
+	}
+	values, _ := result.([]interface{})
+	if len(values) != 3 {
+		return Session{}, ErrNotFound
+	}
+	session := Session{}
+	session.ID, _ = values[1].(string)
+	session.Username, _ = values[2].(string)
+	if values[0] == "reused" {
+		return session, ErrReused
+	}
+	return session, nil
+}
+
+func (store *RedisStore) Revoke(ctx context.Context, id string) error {
+	return store.client.WithContext(ctx).Del(sessionPrefix + id).Err()
+}
+
+func (store *RedisStore) Deny(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return store.client.WithContext(ctx).Set(deniedPrefix+tokenID, "1", ttl).Err()
+}
+
+func (store *RedisStore) Revoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	if tokenID == "" && sessionID == "" {
+		return false, nil
+	}
+	var denied, live *redis.IntCmd
+	_, err := store.client.WithContext(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		if tokenID != "" {
+			denied = pipe.Exists(deniedPrefix + tokenID)
+		}
+		if sessionID != "" {
+			live = pipe.Exists(sessionPrefix + sessionID)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if denied != nil && denied.Val() > 0 {
+		return true, nil
+	}
+	return live != nil && live.Val() == 0, nil
+}
@@ -0,0 +1,50 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"microservice/src/models"
+)
+
+// JWKS returns the public half of every published key, as in RFC 7517.
+func (set *KeySet) JWKS() models.JWKSet {
+	published, _ := set.published()
+	jwks := models.JWKSet{Keys: make([]models.JWK, 0, len(published))}
+	for _, key := range published {
+		jwk := models.JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Algorithm(),
+		}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = encode(padded(public.X, size))
+			jwk.Y = encode(padded(public.Y, size))
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// padded returns n as a big-endian number of exactly size bytes, as JWK
+// coordinates must be.
+func padded(n *big.Int, size int) []byte {
+	bytes := n.Bytes()
+	if len(bytes) >= size {
+		return bytes
+	}
+	return append(make([]byte, size-len(bytes)), bytes...)
+}
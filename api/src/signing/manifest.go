@@ -0,0 +1,87 @@
+package signing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// manifestEntry schedules a key file. Relative paths are resolved from the
+// manifest's directory.
+type manifestEntry struct {
+	ID        string    `json:"kid"`
+	File      string    `json:"file"`
+	NotBefore time.Time `json:"notBefore"`
+}
+
+// LoadManifest reads the keys listed in a JSON manifest such as
+//
+//	[
+//	  {"kid": "2026-09", "file": "2026-09.pem", "notBefore": "2026-09-01T00:00:00Z"},
+//	  {"kid": "2026-10", "file": "2026-10.pem", "notBefore": "2026-10-01T00:00:00Z"}
+//	]
+//
+// Rotating keys means adding an entry ahead of time; the previous key can
+// be removed once its grace period is over.
+func LoadManifest(path string) ([]Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	keys := make([]Key, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.ID == "" || seen[entry.ID] {
+			return nil, fmt.Errorf("%s: every key needs a unique kid", path)
+		}
+		seen[entry.ID] = true
+
+		file := entry.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePrivateKey(entry.ID, pem, entry.NotBefore)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ReloadManifest reloads the keys of set from the manifest at path every
+// interval until ctx is done, so keys can be added without a restart. A
+// manifest that fails to load leaves the current keys in place.
+func ReloadManifest(ctx context.Context, set *KeySet, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		keys, err := LoadManifest(path)
+		if err != nil {
+			log.Error("Failed to reload signing keys: ", err)
+			continue
+		}
+		set.Replace(keys)
+	}
+}
@@ -0,0 +1,208 @@
+// Package signing signs and verifies access tokens.
+//
+// Tokens are signed with RS256 or ES256 keys loaded from files and
+// published as a JSON Web Key Set, so other services can verify tokens
+// without sharing a secret. Keys are scheduled: each becomes the signing
+// key at its NotBefore time, and the key it replaces stays published for a
+// grace period so tokens it signed remain valid until they expire.
+//
+// Without keys, tokens are signed with HS256 and JWT_SECRET as before. A
+// configured secret keeps verifying HS256 tokens for a grace period after
+// the first key becomes active, so switching does not sign anyone out, and
+// stops once the tokens it signed have expired. From then on the secret
+// can no longer be used to forge tokens.
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrNoSigningKey is returned by Sign when no key is active and there
+	// is no secret to fall back on.
+	ErrNoSigningKey = errors.New("no active signing key")
+	// ErrUnknownKey is returned for tokens signed with a key that is not
+	// published, or with another algorithm than their key's.
+	ErrUnknownKey = errors.New("token signed with an unknown key")
+)
+
+// Key is an asymmetric signing key. The algorithm follows from the key
+// type: RS256 for RSA keys, ES256 for P-256 keys.
+type Key struct {
+	ID        string
+	NotBefore time.Time
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+}
+
+// ParsePrivateKey reads a PEM encoded RSA or P-256 private key, in PKCS #8,
+// PKCS #1 or SEC 1 form.
+func ParsePrivateKey(id string, data []byte, notBefore time.Time) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: %v", id, err)
+	}
+
+	key := Key{ID: id, NotBefore: notBefore, private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return Key{}, fmt.Errorf("key %s: RSA keys must have at least 2048 bits", id)
+		}
+		key.method, key.public = jwt.SigningMethodRS256, &private.PublicKey
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("key %s: only P-256 EC keys are supported", id)
+		}
+		key.method, key.public = jwt.SigningMethodES256, &private.PublicKey
+	default:
+		return Key{}, fmt.Errorf("key %s: unsupported key type %T", id, private)
+	}
+	return key, nil
+}
+
+// Algorithm is the JWS algorithm of the key.
+func (key Key) Algorithm() string {
+	return key.method.Alg()
+}
+
+// KeySet holds the signing keys of the API.
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   []Key
+	secret []byte
+	grace  time.Duration
+	now    func() time.Time
+	// secretUntil is when the secret stops verifying tokens, the grace
+	// period after the earliest key ever loaded. Zero without keys.
+	secretUntil time.Time
+}
+
+// NewKeySet signs with keys, keeping replaced keys for grace, or with the
+// HS256 secret when no key is active. secret may be empty.
+func NewKeySet(secret []byte, grace time.Duration, keys ...Key) *KeySet {
+	set := &KeySet{
+		secret: secret,
+		grace:  grace,
+		now:    time.Now,
+	}
+	set.Replace(keys)
+	return set
+}
+
+// Replace swaps in a new list of keys, such as a reloaded manifest.
+func (set *KeySet) Replace(keys []Key) {
+	sorted := append([]Key(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].NotBefore.Before(sorted[j].NotBefore)
+	})
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.keys = sorted
+	if len(sorted) > 0 {
+		// Reloading a manifest without its oldest keys must not reopen
+		// the window.
+		until := sorted[0].NotBefore.Add(set.grace)
+		if set.secretUntil.IsZero() || until.Before(set.secretUntil) {
+			set.secretUntil = until
+		}
+	}
+}
+
+// published returns the keys tokens may be verified with, and the index of
+// the signing key among them, or -1. Keys are published before they become
+// active, so verifiers have them by the time they are used.
+func (set *KeySet) published() ([]Key, int) {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	now := set.now()
+	published := make([]Key, 0, len(set.keys))
+	signing := -1
+	for i, key := range set.keys {
+		if i+1 < len(set.keys) {
+			// Replaced keys are kept for the grace period.
+			if replacedAt := set.keys[i+1].NotBefore; !now.Before(replacedAt.Add(set.grace)) {
+				continue
+			}
+		}
+		if !key.NotBefore.After(now) {
+			signing = len(published)
+		}
+		published = append(published, key)
+	}
+	return published, signing
+}
+
+// secretValid reports whether the secret still verifies tokens signed
+// before keys took over.
+func (set *KeySet) secretValid() bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.secretUntil.IsZero() || set.now().Before(set.secretUntil)
+}
+
+// Sign signs claims with the active key, naming it in the kid header.
+func (set *KeySet) Sign(claims jwt.Claims) (string, error) {
+	published, signing := set.published()
+	if signing < 0 {
+		if len(set.secret) == 0 {
+			return "", ErrNoSigningKey
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(set.secret)
+	}
+
+	key := published[signing]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Keyfunc finds the key verifying a token, for jwt.Parse. The algorithm
+// must be the key's, so a public key can never be used as an HMAC secret.
+// HS256 tokens are accepted while the secret signs tokens, and for the
+// grace period after keys take over.
+func (set *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	published, signing := set.published()
+	if token.Method == jwt.SigningMethodHS256 {
+		if len(set.secret) == 0 || (signing >= 0 && !set.secretValid()) {
+			return nil, ErrUnknownKey
+		}
+		return set.secret, nil
+	}
+
+	id, _ := token.Header["kid"].(string)
+	for _, key := range published {
+		if key.ID == id && key.method == token.Method {
+			return key.public, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
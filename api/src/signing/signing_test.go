@@ -0,0 +1,214 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	start    = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	ecPEM    = generateEC()
+	rsaPEM   = generateRSA()
+	ecdsaKey = mustParse("ec", ecPEM, start)
+	rsaKey   = mustParse("rsa", rsaPEM, start.Add(30*24*time.Hour))
+)
+
+func generateEC() []byte {
+	private, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func generateRSA() []byte {
+	private, _ := rsa.GenerateKey(rand.Reader, 2048)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+}
+
+func mustParse(id string, data []byte, notBefore time.Time) Key {
+	key, err := ParsePrivateKey(id, data, notBefore)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func verify(set *KeySet, token string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, set.Keyfunc)
+	return claims, err
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	assert.Equal(t, "ES256", ecdsaKey.Algorithm())
+	assert.Equal(t, "RS256", rsaKey.Algorithm())
+
+	_, err := ParsePrivateKey("empty", []byte("not a key"), start)
+	assert.NotNil(t, err)
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	_, err = ParsePrivateKey("small", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)}), start)
+	assert.NotNil(t, err)
+
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(p384)
+	_, err = ParsePrivateKey("p384", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), start)
+	assert.NotNil(t, err)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	now := start.Add(-time.Hour)
+	set := NewKeySet(nil, 24*time.Hour, rsaKey, ecdsaKey)
+	set.now = func() time.Time { return now }
+
+	// No key is active yet, but both are published ahead of time.
+	_, err := set.Sign(&jwt.StandardClaims{Subject: "packt"})
+	assert.Equal(t, ErrNoSigningKey, err)
+	assert.Len(t, set.JWKS().Keys, 2)
+
+	now = start
+	first, err := set.Sign(&jwt.StandardClaims{Subject: "packt"})
+	assert.Nil(t, err)
+	token, _, _ := new(jwt.Parser).ParseUnverified(first, &jwt.StandardClaims{})
+	assert.Equal(t, "ec", token.Header["kid"])
+	assert.Equal(t, "ES256", token.Header["alg"])
+
+	now = rsaKey.NotBefore
+	second, err := set.Sign(&jwt.StandardClaims{Subject: "packt"})
+	assert.Nil(t, err)
+	token, _, _ = new(jwt.Parser).ParseUnverified(second, &jwt.StandardClaims{})
+	assert.Equal(t, "rsa", token.Header["kid"])
+
+	// The replaced key verifies during the grace period only.
+	now = now.Add(23 * time.Hour)
+	claims, err := verify(set, first)
+	assert.Nil(t, err)
+	assert.Equal(t, "packt", claims.Subject)
+	_, err = verify(set, second)
+	assert.Nil(t, err)
+
+	now = now.Add(time.Hour)
+	_, err = verify(set, first)
+	assert.NotNil(t, err)
+	_, err = verify(set, second)
+	assert.Nil(t, err)
+	assert.Len(t, set.JWKS().Keys, 1)
+}
+
+func TestKeySetSecret(t *testing.T) {
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Subject: "packt"}).SignedString([]byte("secret"))
+	assert.Nil(t, err)
+
+	// Before keys are active, the secret signs tokens.
+	set := NewKeySet([]byte("secret"), time.Hour, rsaKey)
+	set.now = func() time.Time { return start }
+	token, err := set.Sign(&jwt.StandardClaims{Subject: "packt"})
+	assert.Nil(t, err)
+	parsed, _, _ := new(jwt.Parser).ParseUnverified(token, &jwt.StandardClaims{})
+	assert.Equal(t, "HS256", parsed.Header["alg"])
+
+	// Afterwards it still verifies the tokens it signed, for the grace
+	// period.
+	set.now = func() time.Time { return rsaKey.NotBefore }
+	_, err = verify(set, legacy)
+	assert.Nil(t, err)
+	set.now = func() time.Time { return rsaKey.NotBefore.Add(59 * time.Minute) }
+	_, err = verify(set, legacy)
+	assert.Nil(t, err)
+
+	// Past it, the secret no longer verifies anything, even when a reload
+	// drops the key that started the grace period.
+	set.now = func() time.Time { return rsaKey.NotBefore.Add(time.Hour) }
+	_, err = verify(set, legacy)
+	assert.Equal(t, ErrUnknownKey, err.(*jwt.ValidationError).Inner)
+	later := mustParse("later", rsaPEM, rsaKey.NotBefore.Add(30*time.Minute))
+	set.Replace([]Key{later})
+	_, err = verify(set, legacy)
+	assert.NotNil(t, err)
+
+	// With no key active, the secret signs again and so verifies again.
+	set.Replace(nil)
+	_, err = verify(set, legacy)
+	assert.Nil(t, err)
+
+	_, err = verify(NewKeySet(nil, time.Hour, rsaKey), legacy)
+	assert.NotNil(t, err)
+}
+
+func TestKeyfuncRejectsAlgorithmConfusion(t *testing.T) {
+	set := NewKeySet(nil, time.Hour, ecdsaKey)
+	set.now = func() time.Time { return start }
+
+	// An HMAC token keyed with the published public key must not verify.
+	jwk := set.JWKS().Keys[0]
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Subject: "admin"})
+	forged.Header["kid"] = "ec"
+	signed, _ := forged.SignedString([]byte(jwk.X + jwk.Y))
+	_, err := verify(set, signed)
+	assert.NotNil(t, err)
+
+	// Nor a token naming a key of another type.
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	wrong := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{Subject: "admin"})
+	wrong.Header["kid"] = "ec"
+	signed, _ = wrong.SignedString(other)
+	_, err = verify(set, signed)
+	assert.NotNil(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	set := NewKeySet(nil, time.Hour, ecdsaKey, rsaKey)
+	set.now = func() time.Time { return start }
+	keys := set.JWKS().Keys
+	assert.Len(t, keys, 2)
+
+	assert.Equal(t, "EC", keys[0].KeyType)
+	assert.Equal(t, "P-256", keys[0].Curve)
+	assert.Equal(t, "ES256", keys[0].Algorithm)
+	assert.Equal(t, "sig", keys[0].Use)
+	assert.Len(t, keys[0].X, 43)
+	assert.Len(t, keys[0].Y, 43)
+
+	assert.Equal(t, "RSA", keys[1].KeyType)
+	assert.Equal(t, "rsa", keys[1].KeyID)
+	assert.Equal(t, "AQAB", keys[1].E)
+	assert.Len(t, keys[1].N, 342)
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "ec.pem"), ecPEM, 0o600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "rsa.pem"), rsaPEM, 0o600))
+	manifest := filepath.Join(dir, "keys.json")
+	assert.Nil(t, ioutil.WriteFile(manifest, []byte(`[
+		{"kid": "2026-11", "file": "rsa.pem", "notBefore": "2026-11-01T00:00:00Z"},
+		{"kid": "2026-10", "file": "`+filepath.Join(dir, "ec.pem")+`", "notBefore": "2026-10-01T00:00:00Z"}
+	]`), 0o600))
+
+	keys, err := LoadManifest(manifest)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "RS256", keys[0].Algorithm())
+	assert.Equal(t, start.Add(31*24*time.Hour), keys[0].NotBefore)
+	assert.Equal(t, "ES256", keys[1].Algorithm())
+
+	assert.Nil(t, ioutil.WriteFile(manifest, []byte(`[
+		{"kid": "2026-10", "file": "ec.pem"},
+		{"kid": "2026-10", "file": "rsa.pem"}
+	]`), 0o600))
+	_, err = LoadManifest(manifest)
+	assert.NotNil(t, err)
+
+	_, err = LoadManifest(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
@@ -2,4 +2,9 @@ module create-user
 
 go 1.16
 
-require go.mongodb.org/mongo-driver v1.7.0
+require (
+	go.mongodb.org/mongo-driver v1.7.0
+	microservice v0.0.0
+)
+
+replace microservice => ../api
@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"log"
 	"os"
 
+	"microservice/src/password"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,10 +14,13 @@ import (
 )
 
 func main() {
-	users := map[string]string{
-		"admin":      "fCRmh4Q2J7Rseqkz",
-		"packt":      "RE4zfHB35VPtTkbT",
-		"mlabouardy": "L3nSFRcZzNQ67bcc",
+	users := map[string]struct {
+		password string
+		role     string
+	}{
+		"admin":      {"fCRmh4Q2J7Rseqkz", "admin"},
+		"packt":      {"RE4zfHB35VPtTkbT", "editor"},
+		"mlabouardy": {"L3nSFRcZzNQ67bcc", "contributor"},
 	}
 
 	ctx := context.Background()
@@ -26,12 +30,16 @@ func main() {
 	}
 
 	collection := client.Database(os.Getenv("MONGO_DATABASE")).Collection("users")
-	h := sha256.New()
 
-	for username, password := range users {
+	for username, user := range users {
+		hash, err := password.Hash(user.password)
+		if err != nil {
+			log.Fatal(err)
+		}
 		collection.InsertOne(ctx, bson.M{
 			"username": username,
-			"password": string(h.Sum([]byte(password))),
+			"password": hash,
+			"role":     user.role,
 		})
 	}
 }